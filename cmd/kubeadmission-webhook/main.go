@@ -6,15 +6,21 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // schedule windows may use any IANA time zone
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/cluster"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/events"
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/web"
 )
 
@@ -44,6 +50,34 @@ func run() int {
 			"tls.private-key-file",
 			"File containing the default x509 private key matching --tls.private-key-file.",
 		).Default("/etc/webhook/certs/tls.key").ExistingFile()
+		enableInformers = kingpin.Flag(
+			"kubernetes.enable-informers",
+			"Watch cluster state, which checks such as namespace mixed quotas need.",
+		).Default("false").Bool()
+		kubeconfig = kingpin.Flag(
+			"kubernetes.kubeconfig",
+			"Path to a kubeconfig file. The in-cluster configuration is used if empty.",
+		).Default("").String()
+		resyncPeriod = kingpin.Flag(
+			"kubernetes.resync-period",
+			"Resync period of the informer caches.",
+		).Default("10m").Duration()
+		watchNodes = kingpin.Flag(
+			"kubernetes.watch-nodes",
+			"Watch nodes to check that mixed workloads can be scheduled. Needs --kubernetes.enable-informers.",
+		).Default("false").Bool()
+		enableEvents = kingpin.Flag(
+			"kubernetes.enable-events",
+			"Record Kubernetes events about the workloads the webhook mutates or skips.",
+		).Default("false").Bool()
+		eventQPS = kingpin.Flag(
+			"kubernetes.event-qps",
+			"Average number of events recorded per second. Events beyond the limit are dropped.",
+		).Default("5").Float32()
+		eventBurst = kingpin.Flag(
+			"kubernetes.event-burst",
+			"Maximum number of events recorded in a burst.",
+		).Default("25").Int()
 	)
 
 	promlogConfig := &promlog.Config{}
@@ -58,11 +92,46 @@ func run() int {
 	level.Info(logger).Log("msg", "Starting kubeadmission-webhook", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", version.BuildContext())
 
+	if *watchNodes && !*enableInformers {
+		level.Error(logger).Log("msg", "--kubernetes.watch-nodes needs --kubernetes.enable-informers")
+		return 1
+	}
+
+	var (
+		clusterState  *cluster.Cluster
+		eventRecorder *events.Recorder
+	)
+	if *enableInformers || *enableEvents {
+		restConfig, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading kubernetes client configuration", "err", err)
+			return 1
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error creating kubernetes client", "err", err)
+			return 1
+		}
+		if *enableInformers {
+			clusterState = cluster.New(client, *resyncPeriod, log.With(logger, "component", "cluster"))
+			if *watchNodes {
+				clusterState.WatchNodes()
+			}
+		}
+		if *enableEvents {
+			eventRecorder = events.New(client, *eventQPS, *eventBurst, log.With(logger, "component", "events"))
+			defer eventRecorder.Shutdown()
+		}
+	}
+
 	webHandler := web.New(log.With(logger, "component", "web"), &web.Options{
 		ListenAddress:   *listenAddress,
 		EnableLifecycle: *enableLifecycle,
 		CertFile:        *tlsCertFile,
 		KeyFile:         *tlsKeyFile,
+		Registerer:      prometheus.DefaultRegisterer,
+		Cluster:         clusterState,
+		Events:          eventRecorder,
 		// Flags:           flagsMap,
 	})
 
@@ -80,6 +149,13 @@ func run() int {
 	ctxWeb, cancelWeb := context.WithCancel(context.Background())
 	defer cancelWeb()
 
+	if clusterState != nil {
+		if err := clusterState.Run(ctxWeb); err != nil {
+			level.Error(logger).Log("msg", "Error starting informers", "err", err)
+			return 1
+		}
+	}
+
 	srvCh := make(chan error, 1)
 	go func() {
 		defer close(srvCh)
@@ -1 +1,358 @@
 package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadFileList(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `[
+		{"namespace": "default", "name": "nginx-test", "mixed": true, "priority": 102},
+		{"namespace": "devops", "name": "busybox-test", "mixed": false, "priority": 89}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Mixedreslist) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(cfg.Mixedreslist))
+	}
+	if r := cfg.Mixedreslist[0]; !r.Mixed || r.Priority != 102 || r.Sources["mixed"] != LayerWorkload {
+		t.Errorf("unexpected rule %+v", r)
+	}
+	if r := cfg.Mixedreslist[1]; r.Mixed || r.Priority != 89 || r.Sources["mixed"] != LayerWorkload {
+		t.Errorf("unexpected rule %+v", r)
+	}
+}
+
+func TestLoadFileInheritance(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"global": {"priority": 10},
+		"namespaces": [
+			{"namespace": "batch", "mixed": true, "priority": 50},
+			{"namespace": "online", "allowOptIn": true}
+		],
+		"mixedreslist": [
+			{"namespace": "batch", "name": "override", "mixed": false},
+			{"namespace": "batch", "name": "inherit"},
+			{"namespace": "online", "name": "web", "mixed": true}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rule     *MixedRes
+		mixed    bool
+		priority int64
+		sources  map[string]Layer
+	}{
+		{cfg.Mixedreslist[0], false, 50, map[string]Layer{"mixed": LayerWorkload, "priority": LayerNamespace}},
+		{cfg.Mixedreslist[1], true, 50, map[string]Layer{"mixed": LayerNamespace, "priority": LayerNamespace}},
+		{cfg.Mixedreslist[2], true, 10, map[string]Layer{"mixed": LayerWorkload, "priority": LayerGlobal}},
+	}
+	for _, tt := range tests {
+		if tt.rule.Mixed != tt.mixed || tt.rule.Priority != tt.priority {
+			t.Errorf("%s: got {mixed: %v, priority: %d}, want {mixed: %v, priority: %d}",
+				tt.rule.Name, tt.rule.Mixed, tt.rule.Priority, tt.mixed, tt.priority)
+		}
+		for field, layer := range tt.sources {
+			if tt.rule.Sources[field] != layer {
+				t.Errorf("%s: %s from %q, want %q", tt.rule.Name, field, tt.rule.Sources[field], layer)
+			}
+		}
+	}
+
+	if rule, ok := cfg.NamespaceRule("batch", "unlisted"); !ok || !rule.Mixed || rule.Priority != 50 {
+		t.Errorf("expected namespace rule for batch/unlisted, got %+v", rule)
+	}
+	if _, ok := cfg.NamespaceRule("online", "unlisted"); ok {
+		t.Errorf("namespace without mixed or priority must not match unlisted workloads")
+	}
+	if _, ok := cfg.NamespaceRule("other", "unlisted"); ok {
+		t.Errorf("unknown namespace must not match")
+	}
+}
+
+func TestLoadFileConditions(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"namespaces": [{"namespace": "batch", "conditions": ["object.spec.replicas > 2"]}],
+		"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := cfg.Mixedreslist[0]
+	if len(rule.Conditions) != 1 || rule.Sources["conditions"] != LayerNamespace {
+		t.Fatalf("expected condition inherited from namespace, got %v from %q", rule.Conditions, rule.Sources["conditions"])
+	}
+	met, err := rule.Conditions[0].Eval(map[string]interface{}{"spec": map[string]interface{}{"replicas": float64(3)}}, nil)
+	if err != nil || !met {
+		t.Errorf("expected condition to be met, got %v, %v", met, err)
+	}
+
+	_, err = LoadFile(writeConfig(t, `{
+		"mixedreslist": [{"namespace": "batch", "name": "job", "conditions": ["object.spec.replicas >"]}]
+	}`))
+	if err == nil {
+		t.Fatal("expected invalid condition to be rejected")
+	}
+}
+
+func TestLoadFilePodOverlay(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"global": {"podOverlay": {"schedulerName": "mixed-scheduler"}},
+		"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := cfg.Mixedreslist[0]; rule.PodOverlay == nil || rule.Sources["podOverlay"] != LayerGlobal {
+		t.Errorf("expected overlay inherited from global, got %s from %q", rule.PodOverlay, rule.Sources["podOverlay"])
+	}
+
+	_, err = LoadFile(writeConfig(t, `{
+		"mixedreslist": [{"namespace": "batch", "name": "job", "podOverlay": {"schedulerNmae": "mixed-scheduler"}}]
+	}`))
+	if err == nil {
+		t.Fatal("expected overlay with unknown field to be rejected")
+	}
+}
+
+func TestLoadFileMissingResources(t *testing.T) {
+	for content, valid := range map[string]bool{
+		`{"action": "defaults", "requests": {"cpu": "100m"}}`: true,
+		`{"action": "deny"}`:                              true,
+		`{"action": "ignore"}`:                            false,
+		`{"action": "skip", "requests": {"cpu": "100m"}}`: false,
+		`{"action": "defaults", "limits": {"gpu": "1"}}`:  false,
+	} {
+		_, err := LoadFile(writeConfig(t, `{
+			"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true, "missingResources": `+content+`}]
+		}`))
+		if (err == nil) != valid {
+			t.Errorf("%s: valid = %v, got error %v", content, valid, err)
+		}
+	}
+}
+
+func TestLoadFileSidecars(t *testing.T) {
+	sidecar := `{"name": "reporter", "container": {"name": "reporter", "image": "reporter:{{ .Rule.Priority }}"}}`
+	for content, valid := range map[string]bool{
+		`"sidecars": [` + sidecar + `], "global": {"sidecars": ["reporter"]}`:                                 true,
+		`"global": {"sidecars": ["reporter"]}`:                                                                false,
+		`"sidecars": [` + sidecar + `, ` + sidecar + `]`:                                                      false,
+		`"sidecars": [{"name": "reporter", "container": {"name": "{{ .Rule.Name }}", "image": "reporter"}}]`:  false,
+		`"sidecars": [{"name": "reporter", "container": {"name": "reporter", "image": "{{ .Rule.Priority"}}]`: false,
+		`"sidecars": [{"name": "reporter", "container": {"name": "reporter", "imag": "reporter"}}]`:           false,
+	} {
+		_, err := LoadFile(writeConfig(t, `{`+content+`, "mixedreslist": []}`))
+		if (err == nil) != valid {
+			t.Errorf("%s: valid = %v, got error %v", content, valid, err)
+		}
+	}
+}
+
+func TestLoadFileConversion(t *testing.T) {
+	for content, valid := range map[string]bool{
+		`{"memoryUnit": "Mi", "rounding": "nearest"}`: true,
+		`{}`:                   true,
+		`{"memoryUnit": "MB"}`: false,
+		`{"rounding": "half"}`: false,
+	} {
+		_, err := LoadFile(writeConfig(t, `{"conversion": `+content+`, "mixedreslist": []}`))
+		if (err == nil) != valid {
+			t.Errorf("%s: valid = %v, got error %v", content, valid, err)
+		}
+	}
+}
+
+func TestLoadFileFilters(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"namespaces": [{"namespace": "batch", "operations": ["CREATE"]}],
+		"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true, "kinds": ["Deployment"]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := cfg.Mixedreslist[0]
+	for _, tt := range []struct {
+		kind, operation string
+		want            bool
+	}{
+		{"Deployment", OperationCreate, true},
+		{"Deployment", OperationUpdate, false},
+		{"StatefulSet", OperationCreate, false},
+	} {
+		if got := rule.AppliesTo(tt.kind, tt.operation); got != tt.want {
+			t.Errorf("AppliesTo(%s, %s) = %v, want %v", tt.kind, tt.operation, got, tt.want)
+		}
+	}
+
+	_, err = LoadFile(writeConfig(t, `{"global": {"operations": ["DELETE"]}, "mixedreslist": []}`))
+	if err == nil {
+		t.Fatal("expected unknown operation to be rejected")
+	}
+}
+
+func TestLoadFileEligibility(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"global": {"eligibility": {"minReplicas": 2, "maxPodCPU": "4"}},
+		"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := cfg.Mixedreslist[0]
+	if rule.Eligibility == nil || *rule.Eligibility.MinReplicas != 2 || rule.Eligibility.MaxPodCPU.String() != "4" {
+		t.Errorf("eligibility = %+v", rule.Eligibility)
+	}
+	if rule.Sources["eligibility"] != LayerGlobal {
+		t.Errorf("eligibility source = %q", rule.Sources["eligibility"])
+	}
+
+	_, err = LoadFile(writeConfig(t, `{"mixedreslist": [{"namespace": "batch", "name": "job", "eligibility": {"minReplicas": -1}}]}`))
+	if err == nil {
+		t.Fatal("expected negative minReplicas to be rejected")
+	}
+}
+
+func TestLoadFileFeasibility(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"namespaces": [{"namespace": "batch", "feasibility": {"action": "fallback"}}],
+		"mixedreslist": [
+			{"namespace": "batch", "name": "job", "mixed": true},
+			{"namespace": "default", "name": "web", "mixed": true}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Mixedreslist[0].Feasibility.EffectiveAction(); got != FeasibilityFallback {
+		t.Errorf("batch/job action = %q", got)
+	}
+	if got := cfg.Mixedreslist[1].Feasibility.EffectiveAction(); got != FeasibilityWarn {
+		t.Errorf("default/web action = %q", got)
+	}
+
+	_, err = LoadFile(writeConfig(t, `{"global": {"feasibility": {"action": "deny"}}, "mixedreslist": []}`))
+	if err == nil {
+		t.Fatal("expected unknown action to be rejected")
+	}
+}
+
+func TestLoadFileFailurePolicy(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{"failurePolicy": {"panic": "deny", "deadline": "2s"}, "mixedreslist": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.FailurePolicy
+	if p.Action(FailurePanic) != FailureDeny || p.Action(FailureTimeout) != FailureAllow || p.EffectiveDeadline() != 2*time.Second {
+		t.Errorf("failure policy = %+v", p)
+	}
+	var unset *FailurePolicy
+	if unset.Action(FailureDecode) != FailureAllow || unset.EffectiveDeadline() != DefaultFailureDeadline {
+		t.Errorf("unexpected defaults")
+	}
+
+	_, err = LoadFile(writeConfig(t, `{"failurePolicy": {"decode": "ignore"}, "mixedreslist": []}`))
+	if err == nil {
+		t.Fatal("expected unknown action to be rejected")
+	}
+}
+
+func TestLoadFileKillSwitch(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{"killSwitch": {"namespaces": ["batch"]}, "mixedreslist": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.KillSwitch.Covers("batch") || cfg.KillSwitch.Covers("default") {
+		t.Errorf("kill switch = %+v", cfg.KillSwitch)
+	}
+
+	_, err = LoadFile(writeConfig(t, `{"killSwitch": {"namespaces": ["batch", "batch"]}, "mixedreslist": []}`))
+	if err == nil {
+		t.Fatal("expected a namespace listed twice to be rejected")
+	}
+}
+
+func TestLoadFileAudit(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"global": {"audit": true},
+		"mixedreslist": [
+			{"namespace": "batch", "name": "job", "mixed": true},
+			{"namespace": "batch", "name": "web", "mixed": true, "audit": false}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := cfg.Mixedreslist[0]; !job.Audit || job.Sources["audit"] != LayerGlobal {
+		t.Errorf("batch/job audit = %v from %q", job.Audit, job.Sources["audit"])
+	}
+	if web := cfg.Mixedreslist[1]; web.Audit || web.Sources["audit"] != LayerWorkload {
+		t.Errorf("batch/web audit = %v from %q", web.Audit, web.Sources["audit"])
+	}
+}
+
+func TestLoadFileWindows(t *testing.T) {
+	_, err := LoadFile(writeConfig(t, `{
+		"global": {"windows": [{"name": "night", "start": "22:00", "end": "06:00"}]},
+		"mixedreslist": [
+			{"namespace": "batch", "name": "job", "mixed": true, "windows": [{"name": "night", "start": "20:00", "end": "08:00"}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"unnamed":   `{"mixedreslist": [{"namespace": "batch", "name": "job", "windows": [{"start": "22:00", "end": "06:00"}]}]}`,
+		"duplicate": `{"mixedreslist": [{"namespace": "batch", "name": "job", "windows": [{"name": "night"}, {"name": "night", "weekdays": ["sat"]}]}]}`,
+		"duplicate rule": `{"mixedreslist": [
+			{"namespace": "batch", "name": "job", "windows": [{"name": "night"}]},
+			{"namespace": "batch", "name": "job", "windows": [{"name": "night"}]}
+		]}`,
+		"duplicate namespace": `{"namespaces": [
+			{"namespace": "batch", "windows": [{"name": "night"}]},
+			{"namespace": "batch", "windows": [{"name": "night"}]}
+		], "mixedreslist": []}`,
+	} {
+		if _, err := LoadFile(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: expected the windows to be rejected", name)
+		}
+	}
+}
+
+func TestLoadFileGuardrails(t *testing.T) {
+	cfg, err := LoadFile(writeConfig(t, `{
+		"global": {"guardrails": {"denyPrivileged": true, "maxPodCPU": "8"}},
+		"mixedreslist": [{"namespace": "batch", "name": "job", "mixed": true}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := cfg.Mixedreslist[0].Guardrails; g == nil || !g.DenyPrivileged || g.MaxPodCPU.String() != "8" {
+		t.Errorf("guardrails = %+v", g)
+	}
+
+	for _, content := range []string{
+		`{"global": {"guardrails": {"maxPodCPU": "-1"}}, "mixedreslist": []}`,
+		`{"mixedreslist": [{"namespace": "batch", "name": "job", "guardrails": {"maxPodCPU": "-500m"}}]}`,
+	} {
+		if _, err := LoadFile(writeConfig(t, content)); err == nil {
+			t.Errorf("expected a negative maxPodCPU to be rejected: %s", content)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/expr"
+)
+
+// ConditionVariables are the variables a condition may use: the admitted
+// object and the admission request, both in their JSON form.
+var ConditionVariables = []string{"object", "request"}
+
+// Condition is an expression that must evaluate to true for a rule to
+// apply, such as `object.spec.replicas > 2`. It is written as a plain JSON
+// string in the configuration file.
+type Condition struct {
+	Expression string
+
+	program *expr.Program
+}
+
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.Expression)
+}
+
+func (c Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Expression)
+}
+
+// Compile parses the expression. It must be called before Eval.
+func (c *Condition) Compile() error {
+	p, err := expr.Compile(c.Expression, ConditionVariables...)
+	if err != nil {
+		return fmt.Errorf("condition %q: %w", c.Expression, err)
+	}
+	c.program = p
+	return nil
+}
+
+// Eval evaluates the condition for the given object and request. A
+// condition that was not compiled is an error.
+func (c *Condition) Eval(object, request interface{}) (bool, error) {
+	if c.program == nil {
+		return false, fmt.Errorf("condition %q is not compiled", c.Expression)
+	}
+	return c.program.EvalBool(map[string]interface{}{
+		"object":  object,
+		"request": request,
+	})
+}
@@ -1,23 +1,559 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Layer names a configuration level an effective value can come from.
+type Layer string
+
+const (
+	LayerDefault    Layer = "default"
+	LayerGlobal     Layer = "global"
+	LayerNamespace  Layer = "namespace"
+	LayerWorkload   Layer = "workload"
+	LayerAnnotation Layer = "annotation"
+	// LayerNamespaceAnnotation marks values taken from the annotations of
+	// the namespace.
+	LayerNamespaceAnnotation Layer = "namespaceAnnotation"
+	// LayerSchedule marks mixed as switched off because no window is active.
+	LayerSchedule Layer = "schedule"
+	// LayerQuota marks mixed as switched off by the namespace quota.
+	LayerQuota Layer = "quota"
+	// LayerEligibility marks mixed as switched off because the workload
+	// failed the eligibility checks of its rule.
+	LayerEligibility Layer = "eligibility"
+	// LayerFeasibility marks mixed as switched off because no node could
+	// schedule the workload.
+	LayerFeasibility Layer = "feasibility"
 )
 
+// Settings are the fields every configuration layer may set. A field left
+// unset is inherited from the layer above: workload, namespace, global.
+type Settings struct {
+	Mixed    *bool  `json:"mixed,omitempty"`
+	Priority *int64 `json:"priority,omitempty"`
+	// Windows restrict mixed mode to the given times. Unset means always.
+	Windows []*Window `json:"windows,omitempty"`
+	// Mutators lists the mutators to run, in order. Unset means the default
+	// pipeline.
+	Mutators []string `json:"mutators,omitempty"`
+	// Conditions must all hold for the rule to apply. Unset means none.
+	Conditions []*Condition `json:"conditions,omitempty"`
+	// Patches are applied after the mutators. Unset means none.
+	Patches []*PatchTemplate `json:"patches,omitempty"`
+	// PodOverlay is a partial PodSpec merged into the pod template. Unset
+	// means none.
+	PodOverlay json.RawMessage `json:"podOverlay,omitempty"`
+	// ImageRewrites maps registry prefixes to mirror prefixes. Unset means
+	// none.
+	ImageRewrites map[string]string `json:"imageRewrites,omitempty"`
+	// MissingResources is the policy for containers without cpu or memory
+	// requests or limits. Unset means the limits action.
+	MissingResources *MissingResources `json:"missingResources,omitempty"`
+	// Sidecars names the sidecars injected into mixed pods. Unset means
+	// none.
+	Sidecars []string `json:"sidecars,omitempty"`
+	// Guardrails are the constraints mixed workloads must meet. Unset means
+	// none.
+	Guardrails *Guardrails `json:"guardrails,omitempty"`
+	// Containers selects the containers whose resources are mixed. Unset
+	// means all but the default excludes.
+	Containers *ContainerSelector `json:"containers,omitempty"`
+	// Kinds and Operations limit the requests a rule applies to. Unset
+	// means all.
+	Kinds      []string `json:"kinds,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+	// Users limits the users whose requests a rule applies to. Unset means
+	// all.
+	Users *UserSelector `json:"users,omitempty"`
+	// Eligibility are the checks a workload must pass to be made mixed.
+	// Unset means none.
+	Eligibility *Eligibility `json:"eligibility,omitempty"`
+	// Feasibility is the policy for mixed workloads no node could schedule.
+	// Unset means the warn action.
+	Feasibility *Feasibility `json:"feasibility,omitempty"`
+	// Audit computes the mutation without applying it. Unset means false.
+	Audit *bool `json:"audit,omitempty"`
+}
+
+func (s Settings) empty() bool {
+	return s.Mixed == nil && s.Priority == nil
+}
+
 type MixedRes struct {
-	Namespace string `json:"namespace",omitempty`
-	Name      string `json:"name",omitempty`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
 	Mixed     bool   `json:"mixed,omitempty"`
-	Priority  int64  `json:"priority`
+	Priority  int64  `json:"priority"`
+	// Windows are the times during which Mixed is in effect. Empty means
+	// always.
+	Windows []*Window `json:"windows,omitempty"`
+	// Mutators are the names of the mutators to run, in order. Empty means
+	// the default pipeline.
+	Mutators []string `json:"mutators,omitempty"`
+	// Conditions must all hold for the rule to apply to a workload.
+	Conditions []*Condition `json:"conditions,omitempty"`
+	// Patches are extra JSON Patch operations, rendered per request.
+	Patches []*PatchTemplate `json:"patches,omitempty"`
+	// PodOverlay is a partial PodSpec that is strategic-merged into the pod
+	// template. A container named "*" applies to every container.
+	PodOverlay json.RawMessage `json:"podOverlay,omitempty"`
+	// ImageRewrites maps registry prefixes, such as "docker.io" or
+	// "quay.io/org", to the mirror prefixes that replace them in the images
+	// of mixed workloads.
+	ImageRewrites map[string]string `json:"imageRewrites,omitempty"`
+	// MissingResources is the policy for containers of mixed workloads that
+	// lack cpu or memory requests or limits. Nil means the limits action.
+	MissingResources *MissingResources `json:"missingResources,omitempty"`
+	// Sidecars names the entries of Config.Sidecars injected into the pods
+	// of mixed workloads.
+	Sidecars []string `json:"sidecars,omitempty"`
+	// Guardrails are checked against the mutated workload. A mixed workload
+	// that violates them is denied.
+	Guardrails *Guardrails `json:"guardrails,omitempty"`
+	// Containers selects the containers whose resources are moved to the
+	// cmos.mixed extended resources. Nil means all but the default excludes.
+	Containers *ContainerSelector `json:"containers,omitempty"`
+	// Kinds are the workload kinds, such as "Deployment", the rule applies
+	// to. Empty means all.
+	Kinds []string `json:"kinds,omitempty"`
+	// Operations are the admission operations, "CREATE" or "UPDATE", the
+	// rule applies to. Empty means all.
+	Operations []string `json:"operations,omitempty"`
+	// Users selects, by the user info of the request, whose requests the
+	// rule applies to. Nil means everyone's.
+	Users *UserSelector `json:"users,omitempty"`
+	// Eligibility is checked before a workload is made mixed. A workload
+	// that fails it is admitted as non-mixed.
+	Eligibility *Eligibility `json:"eligibility,omitempty"`
+	// Feasibility is the policy for mixed workloads that no node matching
+	// their node selector could schedule. Nil means the warn action.
+	Feasibility *Feasibility `json:"feasibility,omitempty"`
+	// Audit makes the rule report only: the webhook computes what it would
+	// do, records it and admits the workload unchanged.
+	Audit bool `json:"audit,omitempty"`
+
+	// Sources records which layer supplied each effective field. It is
+	// filled in when the configuration is loaded.
+	Sources map[string]Layer `json:"sources,omitempty"`
+
+	// explicit holds the fields that were actually set in the file.
+	explicit Settings
+}
+
+// The admission operations a rule can be limited to.
+const (
+	OperationCreate = "CREATE"
+	OperationUpdate = "UPDATE"
+)
+
+// AppliesTo reports whether the rule applies to an admission request for
+// the given kind and operation.
+func (r *MixedRes) AppliesTo(kind, operation string) bool {
+	return (len(r.Kinds) == 0 || contains(r.Kinds, kind)) &&
+		(len(r.Operations) == 0 || contains(r.Operations, operation))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON remembers which fields the entry sets itself, so that the
+// others can be inherited.
+func (r *MixedRes) UnmarshalJSON(data []byte) error {
+	type plain MixedRes
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	r.Sources = nil
+	return json.Unmarshal(data, &r.explicit)
+}
+
+// NamespacePolicy holds the settings that apply to every workload of a
+// namespace.
+type NamespacePolicy struct {
+	Namespace string `json:"namespace"`
+	// Settings set here apply to every workload of the namespace unless the
+	// workload entry overrides them.
+	Settings
+	// AllowOptIn lets workloads that are not in Mixedreslist request mixed
+	// mode through the opt-in annotation.
+	AllowOptIn bool `json:"allowOptIn,omitempty"`
+	// MaxPriority caps the priority an opted-in workload may ask for.
+	// Zero means no cap.
+	MaxPriority int64 `json:"maxPriority,omitempty"`
+	// Quota caps the mixed resources the namespace may claim.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// QuotaAction is what happens to a mixed workload that would exceed the
+// quota of its namespace.
+type QuotaAction string
+
+const (
+	// QuotaActionFallback admits the workload as non-mixed.
+	QuotaActionFallback QuotaAction = "fallback"
+	// QuotaActionDeny rejects the workload.
+	QuotaActionDeny QuotaAction = "deny"
+)
+
+// Quota caps the cmos.mixed/cpu and cmos.mixed/memory requests, summed as
+// replicas times container requests, of all mixed workloads of a namespace.
+type Quota struct {
+	CPU    *resource.Quantity `json:"cpu,omitempty"`
+	Memory *resource.Quantity `json:"memory,omitempty"`
+	// Action defaults to fallback.
+	Action QuotaAction `json:"action,omitempty"`
 }
 
 type Config struct {
-	Mixedreslist []*MixedRes
+	Global       Settings           `json:"global,omitempty"`
+	Namespaces   []*NamespacePolicy `json:"namespaces,omitempty"`
+	Mixedreslist []*MixedRes        `json:"mixedreslist"`
+	// Sidecars are the container templates rules refer to by name.
+	Sidecars []*Sidecar `json:"sidecars,omitempty"`
+	// Conversion is how native resources are converted to the cmos.mixed
+	// extended resources. Unset means the defaults.
+	Conversion *Conversion `json:"conversion,omitempty"`
+	// FailurePolicy is how requests the webhook fails to handle are
+	// answered. Unset means they are allowed unchanged.
+	FailurePolicy *FailurePolicy `json:"failurePolicy,omitempty"`
+	// KillSwitch stops mutations. It adds to the kill switch set through
+	// the lifecycle API. Unset means none.
+	KillSwitch *KillSwitch `json:"killSwitch,omitempty"`
+}
+
+// Sidecar returns the sidecar with the given name, or nil if there is none.
+func (c *Config) Sidecar(name string) *Sidecar {
+	for _, s := range c.Sidecars {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Namespace returns the policy configured for the given namespace, or nil if
+// there is none.
+func (c *Config) Namespace(namespace string) *NamespacePolicy {
+	for _, ns := range c.Namespaces {
+		if ns.Namespace == namespace {
+			return ns
+		}
+	}
+	return nil
+}
+
+// NamespaceRule returns the effective rule for a workload that has no entry
+// of its own, if its namespace entry sets mixed or priority.
+func (c *Config) NamespaceRule(namespace, name string) (*MixedRes, bool) {
+	ns := c.Namespace(namespace)
+	if ns == nil || ns.Settings.empty() {
+		return nil, false
+	}
+	return c.Inherited(namespace, name), true
+}
+
+// Inherited returns the rule a workload would get from the namespace and
+// global layers alone, ignoring any workload entry.
+func (c *Config) Inherited(namespace, name string) *MixedRes {
+	rule := &MixedRes{Namespace: namespace, Name: name}
+	c.inherit(rule)
+	return rule
+}
+
+type layerSettings struct {
+	layer Layer
+	Settings
+}
+
+// inherit fills in every field of rule that its own entry does not set.
+func (c *Config) inherit(rule *MixedRes) {
+	// From the most general layer to the most specific one.
+	layers := []layerSettings{{LayerGlobal, c.Global}}
+	if ns := c.Namespace(rule.Namespace); ns != nil {
+		layers = append(layers, layerSettings{LayerNamespace, ns.Settings})
+	}
+	layers = append(layers, layerSettings{LayerWorkload, rule.explicit})
+
+	rule.Mixed, rule.Priority = false, 0
+	rule.Windows, rule.Mutators, rule.Conditions, rule.Patches, rule.PodOverlay = nil, nil, nil, nil, nil
+	rule.ImageRewrites, rule.MissingResources, rule.Sidecars, rule.Guardrails = nil, nil, nil, nil
+	rule.Containers, rule.Kinds, rule.Operations, rule.Users, rule.Eligibility = nil, nil, nil, nil, nil
+	rule.Feasibility, rule.Audit = nil, false
+	rule.Sources = map[string]Layer{
+		"mixed":            LayerDefault,
+		"priority":         LayerDefault,
+		"windows":          LayerDefault,
+		"mutators":         LayerDefault,
+		"conditions":       LayerDefault,
+		"patches":          LayerDefault,
+		"podOverlay":       LayerDefault,
+		"imageRewrites":    LayerDefault,
+		"missingResources": LayerDefault,
+		"sidecars":         LayerDefault,
+		"guardrails":       LayerDefault,
+		"containers":       LayerDefault,
+		"kinds":            LayerDefault,
+		"operations":       LayerDefault,
+		"users":            LayerDefault,
+		"eligibility":      LayerDefault,
+		"feasibility":      LayerDefault,
+		"audit":            LayerDefault,
+	}
+	for _, l := range layers {
+		if l.Mixed != nil {
+			rule.Mixed = *l.Mixed
+			rule.Sources["mixed"] = l.layer
+		}
+		if l.Priority != nil {
+			rule.Priority = *l.Priority
+			rule.Sources["priority"] = l.layer
+		}
+		if l.Windows != nil {
+			rule.Windows = l.Windows
+			rule.Sources["windows"] = l.layer
+		}
+		if l.Mutators != nil {
+			rule.Mutators = l.Mutators
+			rule.Sources["mutators"] = l.layer
+		}
+		if l.Conditions != nil {
+			rule.Conditions = l.Conditions
+			rule.Sources["conditions"] = l.layer
+		}
+		if l.Patches != nil {
+			rule.Patches = l.Patches
+			rule.Sources["patches"] = l.layer
+		}
+		if l.PodOverlay != nil {
+			rule.PodOverlay = l.PodOverlay
+			rule.Sources["podOverlay"] = l.layer
+		}
+		if l.ImageRewrites != nil {
+			rule.ImageRewrites = l.ImageRewrites
+			rule.Sources["imageRewrites"] = l.layer
+		}
+		if l.MissingResources != nil {
+			rule.MissingResources = l.MissingResources
+			rule.Sources["missingResources"] = l.layer
+		}
+		if l.Sidecars != nil {
+			rule.Sidecars = l.Sidecars
+			rule.Sources["sidecars"] = l.layer
+		}
+		if l.Guardrails != nil {
+			rule.Guardrails = l.Guardrails
+			rule.Sources["guardrails"] = l.layer
+		}
+		if l.Containers != nil {
+			rule.Containers = l.Containers
+			rule.Sources["containers"] = l.layer
+		}
+		if l.Kinds != nil {
+			rule.Kinds = l.Kinds
+			rule.Sources["kinds"] = l.layer
+		}
+		if l.Operations != nil {
+			rule.Operations = l.Operations
+			rule.Sources["operations"] = l.layer
+		}
+		if l.Users != nil {
+			rule.Users = l.Users
+			rule.Sources["users"] = l.layer
+		}
+		if l.Eligibility != nil {
+			rule.Eligibility = l.Eligibility
+			rule.Sources["eligibility"] = l.layer
+		}
+		if l.Feasibility != nil {
+			rule.Feasibility = l.Feasibility
+			rule.Sources["feasibility"] = l.layer
+		}
+		if l.Audit != nil {
+			rule.Audit = *l.Audit
+			rule.Sources["audit"] = l.layer
+		}
+	}
+}
+
+// Validate checks the configuration and compiles the parts of it that are
+// evaluated per request. Configurations built in code must be validated
+// before use.
+func (c *Config) Validate() error {
+	all := []Settings{c.Global}
+	namespaces := map[string]bool{}
+	for _, ns := range c.Namespaces {
+		if namespaces[ns.Namespace] {
+			return fmt.Errorf("namespace %q is defined more than once", ns.Namespace)
+		}
+		namespaces[ns.Namespace] = true
+		all = append(all, ns.Settings)
+		if ns.Quota != nil {
+			switch ns.Quota.Action {
+			case "", QuotaActionFallback, QuotaActionDeny:
+			default:
+				return fmt.Errorf("namespace %q: unknown quota action %q", ns.Namespace, ns.Quota.Action)
+			}
+		}
+	}
+	rules := map[string]bool{}
+	for _, rule := range c.Mixedreslist {
+		key := rule.Namespace + "/" + rule.Name
+		if rules[key] {
+			return fmt.Errorf("workload %s is listed more than once", key)
+		}
+		rules[key] = true
+		all = append(all, Settings{
+			Windows:          rule.Windows,
+			Conditions:       rule.Conditions,
+			Patches:          rule.Patches,
+			PodOverlay:       rule.PodOverlay,
+			ImageRewrites:    rule.ImageRewrites,
+			MissingResources: rule.MissingResources,
+			Sidecars:         rule.Sidecars,
+			Containers:       rule.Containers,
+			Kinds:            rule.Kinds,
+			Operations:       rule.Operations,
+			Users:            rule.Users,
+			Eligibility:      rule.Eligibility,
+			Feasibility:      rule.Feasibility,
+			Guardrails:       rule.Guardrails,
+		})
+	}
+
+	if c.Conversion != nil {
+		if err := c.Conversion.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.FailurePolicy != nil {
+		if err := c.FailurePolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.KillSwitch != nil {
+		if err := c.KillSwitch.Validate(); err != nil {
+			return err
+		}
+	}
+
+	containers := map[string]string{}
+	for i, sidecar := range c.Sidecars {
+		if c.Sidecar(sidecar.Name) != c.Sidecars[i] {
+			return fmt.Errorf("sidecar %q is defined more than once", sidecar.Name)
+		}
+		if err := sidecar.Compile(); err != nil {
+			return err
+		}
+		if other, ok := containers[sidecar.ContainerName()]; ok {
+			return fmt.Errorf("sidecars %q and %q inject the same container %q", other, sidecar.Name, sidecar.ContainerName())
+		}
+		containers[sidecar.ContainerName()] = sidecar.Name
+	}
+
+	for _, s := range all {
+		for _, name := range s.Sidecars {
+			if c.Sidecar(name) == nil {
+				return fmt.Errorf("unknown sidecar %q", name)
+			}
+		}
+		names := map[string]bool{}
+		for _, w := range s.Windows {
+			if w.Name == "" {
+				return fmt.Errorf("windows: every window needs a name")
+			}
+			if names[w.Name] {
+				return fmt.Errorf("window %q is defined more than once", w.Name)
+			}
+			names[w.Name] = true
+			if err := w.Compile(); err != nil {
+				return err
+			}
+		}
+		for _, cond := range s.Conditions {
+			if err := cond.Compile(); err != nil {
+				return err
+			}
+		}
+		for _, p := range s.Patches {
+			if err := p.Compile(); err != nil {
+				return err
+			}
+		}
+		if s.PodOverlay != nil {
+			dec := json.NewDecoder(bytes.NewReader(s.PodOverlay))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&corev1.PodSpec{}); err != nil {
+				return fmt.Errorf("podOverlay: %w", err)
+			}
+		}
+		for prefix, mirror := range s.ImageRewrites {
+			if strings.Trim(prefix, "/") == "" || strings.Trim(mirror, "/") == "" {
+				return fmt.Errorf("imageRewrites: %q: %q: prefix and mirror must not be empty", prefix, mirror)
+			}
+		}
+		if s.MissingResources != nil {
+			if err := s.MissingResources.Validate(); err != nil {
+				return err
+			}
+		}
+		if s.Containers != nil {
+			if err := s.Containers.Validate(); err != nil {
+				return err
+			}
+		}
+		if s.Users != nil {
+			if err := s.Users.Validate(); err != nil {
+				return err
+			}
+		}
+		if s.Eligibility != nil {
+			if err := s.Eligibility.Validate(); err != nil {
+				return err
+			}
+		}
+		if s.Feasibility != nil {
+			if err := s.Feasibility.Validate(); err != nil {
+				return err
+			}
+		}
+		if s.Guardrails != nil {
+			if err := s.Guardrails.Validate(); err != nil {
+				return err
+			}
+		}
+		for _, kind := range s.Kinds {
+			if kind == "" {
+				return fmt.Errorf("kinds: empty kind")
+			}
+		}
+		for _, op := range s.Operations {
+			if op != OperationCreate && op != OperationUpdate {
+				return fmt.Errorf("operations: unknown operation %q", op)
+			}
+		}
+	}
+	return nil
 }
 
 // type MixdList []*Config
 
+// LoadFile reads the configuration file. Besides the full object form, the
+// file may still be a bare JSON list of MixedRes entries.
 func LoadFile(filename string) (*Config, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
@@ -25,9 +561,20 @@ func LoadFile(filename string) (*Config, error) {
 	}
 
 	cfg := &Config{}
-	err = json.Unmarshal(content, &cfg.Mixedreslist)
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		err = json.Unmarshal(content, &cfg.Mixedreslist)
+	} else {
+		err = json.Unmarshal(content, cfg)
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	for _, rule := range cfg.Mixedreslist {
+		cfg.inherit(rule)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// DefaultExcludedContainers are well-known sidecars that keep their native
+// resources unless a selector ignores the default excludes.
+var DefaultExcludedContainers = []string{"istio-proxy", "linkerd-proxy"}
+
+// ContainerSelector chooses, by name or path.Match glob pattern, the
+// containers whose resources are moved to the cmos.mixed extended resources.
+type ContainerSelector struct {
+	// Include selects only the matching containers. Empty means all.
+	Include []string `json:"include,omitempty"`
+	// Exclude leaves out the matching containers, in addition to
+	// DefaultExcludedContainers.
+	Exclude []string `json:"exclude,omitempty"`
+	// IgnoreDefaultExcludes lets DefaultExcludedContainers be selected.
+	IgnoreDefaultExcludes bool `json:"ignoreDefaultExcludes,omitempty"`
+}
+
+// Validate checks the patterns.
+func (s *ContainerSelector) Validate() error {
+	for _, pattern := range append(append([]string{}, s.Include...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("containers: pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Selected reports whether the container with the given name is selected. A
+// nil selector selects every container but the default excludes.
+func (s *ContainerSelector) Selected(name string) bool {
+	if s == nil {
+		return !matchAny(DefaultExcludedContainers, name)
+	}
+	if len(s.Include) > 0 && !matchAny(s.Include, name) {
+		return false
+	}
+	if !s.IgnoreDefaultExcludes && matchAny(DefaultExcludedContainers, name) {
+		return false
+	}
+	return !matchAny(s.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestContainerSelector(t *testing.T) {
+	tests := []struct {
+		selector *ContainerSelector
+		name     string
+		want     bool
+	}{
+		{nil, "app", true},
+		{nil, "istio-proxy", false},
+		{nil, "linkerd-proxy", false},
+		{&ContainerSelector{}, "istio-proxy", false},
+		{&ContainerSelector{IgnoreDefaultExcludes: true}, "istio-proxy", true},
+		{&ContainerSelector{Include: []string{"app", "worker-*"}}, "worker-1", true},
+		{&ContainerSelector{Include: []string{"app", "worker-*"}}, "log", false},
+		{&ContainerSelector{Include: []string{"*"}}, "istio-proxy", false},
+		{&ContainerSelector{Exclude: []string{"*-logger", "fluent?bit"}}, "app-logger", false},
+		{&ContainerSelector{Exclude: []string{"*-logger", "fluent?bit"}}, "fluent-bit", false},
+		{&ContainerSelector{Exclude: []string{"*-logger", "fluent?bit"}}, "app", true},
+		{&ContainerSelector{Include: []string{"worker-*"}, Exclude: []string{"worker-debug"}}, "worker-debug", false},
+	}
+	for _, tt := range tests {
+		if got := tt.selector.Selected(tt.name); got != tt.want {
+			t.Errorf("%+v.Selected(%q) = %v, want %v", tt.selector, tt.name, got, tt.want)
+		}
+	}
+
+	if err := (&ContainerSelector{Exclude: []string{"["}}).Validate(); err == nil {
+		t.Error("expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+)
+
+// MemoryUnit is the unit of the cmos.mixed/memory extended resource.
+type MemoryUnit string
+
+const (
+	MemoryUnitBytes MemoryUnit = "bytes"
+	MemoryUnitKi    MemoryUnit = "Ki"
+	MemoryUnitMi    MemoryUnit = "Mi"
+	MemoryUnitGi    MemoryUnit = "Gi"
+)
+
+// memoryUnitBytes are the sizes of the memory units.
+var memoryUnitBytes = map[MemoryUnit]int64{
+	MemoryUnitBytes: 1,
+	MemoryUnitKi:    1 << 10,
+	MemoryUnitMi:    1 << 20,
+	MemoryUnitGi:    1 << 30,
+}
+
+// Rounding is how a quantity that is not a whole number of units is made
+// one.
+type Rounding string
+
+const (
+	RoundingUp      Rounding = "up"
+	RoundingDown    Rounding = "down"
+	RoundingNearest Rounding = "nearest"
+)
+
+// Conversion is how native cpu and memory quantities become the whole
+// numbers extended resources must be. cmos.mixed/cpu is always in
+// millicores. It applies to the whole cluster, since it has to match the
+// capacity the nodes advertise.
+type Conversion struct {
+	// MemoryUnit defaults to bytes.
+	MemoryUnit MemoryUnit `json:"memoryUnit,omitempty"`
+	// Rounding defaults to up, so that a workload never asks for less than
+	// it requested natively.
+	Rounding Rounding `json:"rounding,omitempty"`
+}
+
+// Validate checks the unit and the rounding mode.
+func (c *Conversion) Validate() error {
+	if _, ok := memoryUnitBytes[c.MemoryUnit]; !ok && c.MemoryUnit != "" {
+		return fmt.Errorf("conversion: unknown memory unit %q", c.MemoryUnit)
+	}
+	switch c.Rounding {
+	case "", RoundingUp, RoundingDown, RoundingNearest:
+	default:
+		return fmt.Errorf("conversion: unknown rounding %q", c.Rounding)
+	}
+	return nil
+}
+
+// MemoryUnitBytes returns the size of the memory unit in bytes. A nil
+// conversion uses the defaults.
+func (c *Conversion) MemoryUnitBytes() int64 {
+	if c == nil || c.MemoryUnit == "" {
+		return 1
+	}
+	return memoryUnitBytes[c.MemoryUnit]
+}
+
+// RoundingMode returns the rounding mode. A nil conversion uses the
+// defaults.
+func (c *Conversion) RoundingMode() Rounding {
+	if c == nil || c.Rounding == "" {
+		return RoundingUp
+	}
+	return c.Rounding
+}
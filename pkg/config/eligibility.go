@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Eligibility are the checks a workload must pass to be made mixed. A
+// workload that fails any of them is admitted as non-mixed instead. Every
+// check is off unless set.
+type Eligibility struct {
+	// MinReplicas is the fewest replicas a mixed workload may have.
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// NoPersistentVolumeClaims makes workloads with persistentVolumeClaim or
+	// ephemeral volumes ineligible.
+	NoPersistentVolumeClaims bool `json:"noPersistentVolumeClaims,omitempty"`
+	// MaxPodCPU and MaxPodMemory cap the native cpu and memory all
+	// containers of a pod request.
+	MaxPodCPU    *resource.Quantity `json:"maxPodCPU,omitempty"`
+	MaxPodMemory *resource.Quantity `json:"maxPodMemory,omitempty"`
+}
+
+// Validate checks that no limit is negative.
+func (e *Eligibility) Validate() error {
+	if e.MinReplicas != nil && *e.MinReplicas < 0 {
+		return fmt.Errorf("eligibility: minReplicas must not be negative, got %d", *e.MinReplicas)
+	}
+	for name, q := range map[string]*resource.Quantity{"maxPodCPU": e.MaxPodCPU, "maxPodMemory": e.MaxPodMemory} {
+		if q != nil && q.Sign() < 0 {
+			return fmt.Errorf("eligibility: %s must not be negative, got %s", name, q.String())
+		}
+	}
+	return nil
+}
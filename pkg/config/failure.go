@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// FailureClass names a class of errors the webhook can run into while
+// handling a request.
+type FailureClass string
+
+const (
+	// FailureDecode is an admission request or object that cannot be
+	// decoded.
+	FailureDecode FailureClass = "decode"
+	// FailureUnsupportedKind is an object of a kind the webhook does not
+	// handle.
+	FailureUnsupportedKind FailureClass = "unsupportedKind"
+	// FailurePatchMarshal is a patch that cannot be encoded.
+	FailurePatchMarshal FailureClass = "patchMarshal"
+	// FailureMutator is a mutator that cannot produce its patch.
+	FailureMutator FailureClass = "mutator"
+	// FailurePanic is a panic while handling the request.
+	FailurePanic FailureClass = "panic"
+	// FailureTimeout is a request that is not handled within the deadline.
+	FailureTimeout FailureClass = "timeout"
+)
+
+// FailureClasses lists every failure class.
+var FailureClasses = []FailureClass{FailureDecode, FailureUnsupportedKind, FailurePatchMarshal, FailureMutator, FailurePanic, FailureTimeout}
+
+// FailureAction is what happens to a request that failed.
+type FailureAction string
+
+const (
+	// FailureAllow admits the object unchanged. It is the default, so that
+	// a broken webhook never blocks workloads.
+	FailureAllow FailureAction = "allow"
+	// FailureDeny rejects the request.
+	FailureDeny FailureAction = "deny"
+)
+
+// DefaultFailureDeadline is the default time a request may take. It is
+// below the default timeoutSeconds of the webhook configuration, so that the
+// timeout action is taken before the API server gives up.
+const DefaultFailureDeadline = 4 * time.Second
+
+// FailurePolicy says, for each failure class, whether the request is allowed
+// unchanged or denied. Every class defaults to allow.
+type FailurePolicy struct {
+	Decode          FailureAction `json:"decode,omitempty"`
+	UnsupportedKind FailureAction `json:"unsupportedKind,omitempty"`
+	PatchMarshal    FailureAction `json:"patchMarshal,omitempty"`
+	Mutator         FailureAction `json:"mutator,omitempty"`
+	Panic           FailureAction `json:"panic,omitempty"`
+	Timeout         FailureAction `json:"timeout,omitempty"`
+	// Deadline is how long a request may take before the timeout action is
+	// taken. It defaults to DefaultFailureDeadline.
+	Deadline model.Duration `json:"deadline,omitempty"`
+}
+
+func (p *FailurePolicy) actions() map[FailureClass]FailureAction {
+	return map[FailureClass]FailureAction{
+		FailureDecode:          p.Decode,
+		FailureUnsupportedKind: p.UnsupportedKind,
+		FailurePatchMarshal:    p.PatchMarshal,
+		FailureMutator:         p.Mutator,
+		FailurePanic:           p.Panic,
+		FailureTimeout:         p.Timeout,
+	}
+}
+
+// Validate checks the actions and the deadline.
+func (p *FailurePolicy) Validate() error {
+	for _, class := range FailureClasses {
+		switch action := p.actions()[class]; action {
+		case "", FailureAllow, FailureDeny:
+		default:
+			return fmt.Errorf("failurePolicy: %s: unknown action %q", class, action)
+		}
+	}
+	if p.Deadline < 0 {
+		return fmt.Errorf("failurePolicy: deadline must not be negative, got %s", p.Deadline)
+	}
+	return nil
+}
+
+// Action returns the action for a failure class. A nil policy uses the
+// defaults.
+func (p *FailurePolicy) Action(class FailureClass) FailureAction {
+	if p == nil {
+		return FailureAllow
+	}
+	if action := p.actions()[class]; action != "" {
+		return action
+	}
+	return FailureAllow
+}
+
+// EffectiveDeadline returns the deadline. A nil policy uses the default.
+func (p *FailurePolicy) EffectiveDeadline() time.Duration {
+	if p == nil || p.Deadline == 0 {
+		return DefaultFailureDeadline
+	}
+	return time.Duration(p.Deadline)
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+)
+
+// FeasibilityAction is what happens to a mixed workload that no node could
+// ever schedule.
+type FeasibilityAction string
+
+const (
+	// FeasibilityWarn admits the workload as mixed with a warning. It is the
+	// default.
+	FeasibilityWarn FeasibilityAction = "warn"
+	// FeasibilityFallback admits the workload as non-mixed.
+	FeasibilityFallback FeasibilityAction = "fallback"
+	// FeasibilityIgnore skips the check.
+	FeasibilityIgnore FeasibilityAction = "ignore"
+)
+
+// Feasibility is the policy for mixed workloads that no node matching their
+// node selector has the allocatable extended resources for. The check needs
+// the node informer.
+type Feasibility struct {
+	// Action defaults to warn.
+	Action FeasibilityAction `json:"action,omitempty"`
+}
+
+// Validate checks the action.
+func (f *Feasibility) Validate() error {
+	switch f.Action {
+	case "", FeasibilityWarn, FeasibilityFallback, FeasibilityIgnore:
+		return nil
+	}
+	return fmt.Errorf("feasibility: unknown action %q", f.Action)
+}
+
+// EffectiveAction returns the configured action. A nil policy uses the
+// default.
+func (f *Feasibility) EffectiveAction() FeasibilityAction {
+	if f == nil || f.Action == "" {
+		return FeasibilityWarn
+	}
+	return f.Action
+}
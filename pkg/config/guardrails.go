@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Guardrails are constraints a mixed workload must meet to be admitted.
+// Every constraint is off unless set.
+type Guardrails struct {
+	// DenyHostNetwork rejects pods that use the host network.
+	DenyHostNetwork bool `json:"denyHostNetwork,omitempty"`
+	// DenyPrivileged rejects pods with privileged containers or init
+	// containers.
+	DenyPrivileged bool `json:"denyPrivileged,omitempty"`
+	// DenyHostPath rejects pods with hostPath volumes.
+	DenyHostPath bool `json:"denyHostPath,omitempty"`
+	// MaxPodCPU caps the cpu all containers of a pod request through
+	// cmos.mixed/cpu, in cores like a native cpu quantity.
+	MaxPodCPU *resource.Quantity `json:"maxPodCPU,omitempty"`
+}
+
+// Validate checks that no cap is negative.
+func (g *Guardrails) Validate() error {
+	if g.MaxPodCPU != nil && g.MaxPodCPU.Sign() < 0 {
+		return fmt.Errorf("guardrails: maxPodCPU must not be negative, got %s", g.MaxPodCPU.String())
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+)
+
+// KillSwitch stops all mutations, for every namespace or for the listed
+// ones. Requests it covers are allowed without a patch.
+type KillSwitch struct {
+	// All covers every namespace.
+	All bool `json:"all,omitempty"`
+	// Namespaces are covered even if All is not set.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Validate checks that the namespaces are neither empty nor listed twice.
+func (k *KillSwitch) Validate() error {
+	seen := map[string]bool{}
+	for _, ns := range k.Namespaces {
+		if ns == "" {
+			return fmt.Errorf("killSwitch: empty namespace")
+		}
+		if seen[ns] {
+			return fmt.Errorf("killSwitch: namespace %q is listed twice", ns)
+		}
+		seen[ns] = true
+	}
+	return nil
+}
+
+// Covers reports whether the kill switch stops mutations in namespace. A nil
+// kill switch covers nothing.
+func (k *KillSwitch) Covers(namespace string) bool {
+	return k != nil && (k.All || contains(k.Namespaces, namespace))
+}
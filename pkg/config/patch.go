@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// PatchData is what patch templates are rendered with. Object and Request are
+// in their JSON form, as for conditions.
+type PatchData struct {
+	Object  interface{}
+	Request interface{}
+	Rule    *MixedRes
+}
+
+// PatchTemplate is a JSON Patch (RFC 6902) operation whose path and string
+// values are Go templates over PatchData, such as
+//
+//	{"op": "add", "path": "/spec/template/metadata/labels/team", "value": "{{ .Object.metadata.namespace }}"}
+type PatchTemplate struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	value     interface{}
+	templates map[string]*template.Template
+}
+
+// Compile parses the templates of the operation. It must be called before
+// Render.
+func (p *PatchTemplate) Compile() error {
+	switch p.Op {
+	case "add", "replace", "test":
+		if len(p.Value) == 0 {
+			return fmt.Errorf("patch %s %s: value is required", p.Op, p.Path)
+		}
+	case "move", "copy":
+		if !strings.HasPrefix(p.From, "/") {
+			return fmt.Errorf("patch %s %s: from must be a JSON pointer", p.Op, p.Path)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("patch %s %s: unknown op", p.Op, p.Path)
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("patch %s %s: path must be a JSON pointer", p.Op, p.Path)
+	}
+
+	p.value = nil
+	if len(p.Value) > 0 {
+		if err := json.Unmarshal(p.Value, &p.value); err != nil {
+			return fmt.Errorf("patch %s %s: %w", p.Op, p.Path, err)
+		}
+	}
+	p.templates = map[string]*template.Template{}
+	for _, s := range append([]string{p.Path, p.From}, templateStrings(p.value)...) {
+		if _, ok := p.templates[s]; ok {
+			continue
+		}
+		t, err := template.New(p.Path).Option("missingkey=error").Parse(s)
+		if err != nil {
+			return fmt.Errorf("patch %s %s: %w", p.Op, p.Path, err)
+		}
+		p.templates[s] = t
+	}
+	return nil
+}
+
+// Render returns the operation with all templates executed.
+func (p *PatchTemplate) Render(data *PatchData) (path, from string, value interface{}, err error) {
+	if path, err = p.execute(p.Path, data); err != nil {
+		return "", "", nil, err
+	}
+	if from, err = p.execute(p.From, data); err != nil {
+		return "", "", nil, err
+	}
+	value, err = p.render(p.value, data)
+	return path, from, value, err
+}
+
+func (p *PatchTemplate) execute(s string, data *PatchData) (string, error) {
+	var buf bytes.Buffer
+	if err := p.templates[s].Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("patch %s %s: %w", p.Op, p.Path, err)
+	}
+	return buf.String(), nil
+}
+
+func (p *PatchTemplate) render(v interface{}, data *PatchData) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return p.execute(v, data)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			r, err := p.render(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			r, err := p.render(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// templateStrings returns every string inside a decoded JSON value.
+func templateStrings(v interface{}) (s []string) {
+	switch v := v.(type) {
+	case string:
+		s = append(s, v)
+	case []interface{}:
+		for _, elem := range v {
+			s = append(s, templateStrings(elem)...)
+		}
+	case map[string]interface{}:
+		for _, elem := range v {
+			s = append(s, templateStrings(elem)...)
+		}
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPatchTemplateRender(t *testing.T) {
+	var p PatchTemplate
+	if err := json.Unmarshal([]byte(`{
+		"op": "add",
+		"path": "/spec/template/metadata/labels/{{ .Request.namespace }}",
+		"value": {"name": "PRIORITY", "value": "{{ .Rule.Priority }}", "list": ["{{ .Object.metadata.name }}", 1, true]}
+	}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	path, from, value, err := p.Render(&PatchData{
+		Object:  map[string]interface{}{"metadata": map[string]interface{}{"name": "nginx"}},
+		Request: map[string]interface{}{"namespace": "default"},
+		Rule:    &MixedRes{Priority: 60},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/spec/template/metadata/labels/default" || from != "" {
+		t.Errorf("path = %q, from = %q", path, from)
+	}
+	want := map[string]interface{}{"name": "PRIORITY", "value": "60", "list": []interface{}{"nginx", float64(1), true}}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("value = %#v, want %#v", value, want)
+	}
+
+	if _, _, _, err := p.Render(&PatchData{Object: map[string]interface{}{}, Request: map[string]interface{}{}, Rule: &MixedRes{}}); err == nil {
+		t.Error("expected missing keys to fail rendering")
+	}
+}
+
+func TestPatchTemplateCompileErrors(t *testing.T) {
+	for _, p := range []PatchTemplate{
+		{Op: "merge", Path: "/spec"},
+		{Op: "add", Path: "/spec"},
+		{Op: "add", Path: "spec", Value: json.RawMessage(`"x"`)},
+		{Op: "move", Path: "/spec/a"},
+		{Op: "add", Path: "/spec/{{ .Rule.Name", Value: json.RawMessage(`"x"`)},
+		{Op: "add", Path: "/spec", Value: json.RawMessage(`"{{ end }}"`)},
+	} {
+		if err := p.Compile(); err == nil {
+			t.Errorf("expected error for %s %s", p.Op, p.Path)
+		}
+	}
+}
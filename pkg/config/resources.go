@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MissingResourcesAction is what happens to a container of a mixed workload
+// that lacks a cpu or memory request or limit.
+type MissingResourcesAction string
+
+const (
+	// MissingResourcesLimits takes a missing request from the limit and a
+	// missing limit from the request. It is the default.
+	MissingResourcesLimits MissingResourcesAction = "limits"
+	// MissingResourcesDefaults takes missing values from the configured
+	// requests and limits.
+	MissingResourcesDefaults MissingResourcesAction = "defaults"
+	// MissingResourcesSkip leaves the resources of the container alone.
+	MissingResourcesSkip MissingResourcesAction = "skip"
+	// MissingResourcesDeny rejects the workload.
+	MissingResourcesDeny MissingResourcesAction = "deny"
+)
+
+// MissingResources is the policy for containers without cpu or memory
+// requests or limits.
+type MissingResources struct {
+	// Action defaults to limits.
+	Action MissingResourcesAction `json:"action,omitempty"`
+	// Requests and Limits are the cpu and memory defaults of the defaults
+	// action.
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	Limits   corev1.ResourceList `json:"limits,omitempty"`
+}
+
+// Validate checks the action and the defaults.
+func (m *MissingResources) Validate() error {
+	switch m.Action {
+	case "", MissingResourcesLimits, MissingResourcesSkip, MissingResourcesDeny:
+		if len(m.Requests) > 0 || len(m.Limits) > 0 {
+			return fmt.Errorf("missingResources: requests and limits are only used by the %s action", MissingResourcesDefaults)
+		}
+	case MissingResourcesDefaults:
+		for _, list := range []corev1.ResourceList{m.Requests, m.Limits} {
+			for name := range list {
+				if name != corev1.ResourceCPU && name != corev1.ResourceMemory {
+					return fmt.Errorf("missingResources: unsupported default resource %q", name)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("missingResources: unknown action %q", m.Action)
+	}
+	return nil
+}
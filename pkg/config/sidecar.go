@@ -0,0 +1,65 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sidecar is a named container template that rules inject into mixed pods.
+// The string values of the container are Go templates over PatchData, such
+// as "{{ .Rule.Name }}" or "{{ .Rule.Priority }}". The container name must be
+// a plain string, since it identifies the injected container on later
+// updates.
+type Sidecar struct {
+	Name      string          `json:"name"`
+	Container json.RawMessage `json:"container"`
+
+	containerName string
+	template      PatchTemplate
+}
+
+// Compile checks the container and parses its templates. It must be called
+// before Render.
+func (s *Sidecar) Compile() error {
+	var c corev1.Container
+	dec := json.NewDecoder(bytes.NewReader(s.Container))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return fmt.Errorf("sidecar %q: %w", s.Name, err)
+	}
+	if c.Name == "" || strings.Contains(c.Name, "{{") {
+		return fmt.Errorf("sidecar %q: container name must be a plain, non-empty string", s.Name)
+	}
+	s.containerName = c.Name
+	s.template = PatchTemplate{Op: "add", Path: "/spec/template/spec/containers/-", Value: s.Container}
+	if err := s.template.Compile(); err != nil {
+		return fmt.Errorf("sidecar %q: %w", s.Name, err)
+	}
+	return nil
+}
+
+// ContainerName returns the name of the container the sidecar injects.
+func (s *Sidecar) ContainerName() string {
+	return s.containerName
+}
+
+// Render returns the container with all templates executed.
+func (s *Sidecar) Render(data *PatchData) (*corev1.Container, error) {
+	_, _, value, err := s.template.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("sidecar %q: %w", s.Name, err)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("sidecar %q: %w", s.Name, err)
+	}
+	c := &corev1.Container{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("sidecar %q: %w", s.Name, err)
+	}
+	return c, nil
+}
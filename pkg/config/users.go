@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// serviceAccountPrefix is the username prefix of service accounts.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// UserMatcher matches the user of an admission request. Every value is
+// either exact or, ending in "*" or ":", a prefix, such as
+// "system:serviceaccount:ci:*" or "system:serviceaccount:ci:". A value
+// ending in ":" cannot name a user, group or service account exactly.
+type UserMatcher struct {
+	Usernames []string `json:"usernames,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	// ServiceAccounts are "namespace:name" values, matched against the
+	// usernames of service accounts.
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+// Validate checks the values.
+func (m *UserMatcher) Validate() error {
+	for _, list := range [][]string{m.Usernames, m.Groups, m.ServiceAccounts} {
+		for _, v := range list {
+			if v == "" || strings.Contains(strings.TrimSuffix(v, "*"), "*") {
+				return fmt.Errorf("users: invalid value %q: must be non-empty and may only end in *", v)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the user or one of its groups matches.
+func (m *UserMatcher) Matches(username string, groups []string) bool {
+	if matchUser(m.Usernames, username) {
+		return true
+	}
+	if strings.HasPrefix(username, serviceAccountPrefix) && matchUser(m.ServiceAccounts, strings.TrimPrefix(username, serviceAccountPrefix)) {
+		return true
+	}
+	for _, g := range groups {
+		if matchUser(m.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchUser(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if prefix := strings.TrimSuffix(p, "*"); prefix != p || strings.HasSuffix(p, ":") {
+			if strings.HasPrefix(value, prefix) {
+				return true
+			}
+		} else if p == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSelector limits a rule to the requests of some users.
+type UserSelector struct {
+	// Include selects only the matching users. Unset means all.
+	Include *UserMatcher `json:"include,omitempty"`
+	// Exclude leaves out the matching users, even if they are included.
+	Exclude *UserMatcher `json:"exclude,omitempty"`
+}
+
+// Validate checks both matchers.
+func (s *UserSelector) Validate() error {
+	for _, m := range []*UserMatcher{s.Include, s.Exclude} {
+		if m == nil {
+			continue
+		}
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Selected reports whether the requests of the user are selected. A nil
+// selector selects every user.
+func (s *UserSelector) Selected(username string, groups []string) bool {
+	if s == nil {
+		return true
+	}
+	if s.Include != nil && !s.Include.Matches(username, groups) {
+		return false
+	}
+	return s.Exclude == nil || !s.Exclude.Matches(username, groups)
+}
@@ -0,0 +1,64 @@
+package config
+
+import "testing"
+
+func TestUserSelector(t *testing.T) {
+	selector := &UserSelector{
+		Include: &UserMatcher{
+			Usernames:       []string{"deployer@example.com"},
+			ServiceAccounts: []string{"ci:*", "argocd:argocd-application-controller"},
+			Groups:          []string{"team-batch"},
+		},
+		Exclude: &UserMatcher{Groups: []string{"sre-break-glass"}},
+	}
+
+	tests := []struct {
+		username string
+		groups   []string
+		want     bool
+	}{
+		{"deployer@example.com", nil, true},
+		{"deployer@example.org", nil, false},
+		{"system:serviceaccount:ci:deployer", []string{"system:serviceaccounts"}, true},
+		{"system:serviceaccount:ci-other:deployer", nil, false},
+		{"system:serviceaccount:argocd:argocd-application-controller", nil, true},
+		{"system:serviceaccount:argocd:argocd-server", nil, false},
+		{"alice", []string{"team-batch"}, true},
+		{"alice", []string{"team-batch", "sre-break-glass"}, false},
+		{"deployer@example.com", []string{"sre-break-glass"}, false},
+	}
+	for _, tt := range tests {
+		if got := selector.Selected(tt.username, tt.groups); got != tt.want {
+			t.Errorf("Selected(%q, %v) = %v, want %v", tt.username, tt.groups, got, tt.want)
+		}
+	}
+
+	prefix := &UserSelector{Include: &UserMatcher{Usernames: []string{"system:serviceaccount:ci:*"}}}
+	if !prefix.Selected("system:serviceaccount:ci:deployer", nil) || prefix.Selected("system:serviceaccount:cd:deployer", nil) {
+		t.Errorf("username prefix did not match as expected")
+	}
+	colon := &UserSelector{Include: &UserMatcher{
+		Usernames:       []string{"system:serviceaccount:ci:"},
+		ServiceAccounts: []string{"cd:"},
+	}}
+	for username, want := range map[string]bool{
+		"system:serviceaccount:ci:deployer":  true,
+		"system:serviceaccount:cd:deployer":  true,
+		"system:serviceaccount:ci-x:builder": false,
+		"system:serviceaccount:cdx:deployer": false,
+	} {
+		if got := colon.Selected(username, nil); got != want {
+			t.Errorf("a value ending in a colon: Selected(%q) = %v, want %v", username, got, want)
+		}
+	}
+	var none *UserSelector
+	if !none.Selected("anyone", nil) {
+		t.Errorf("a nil selector must select everyone")
+	}
+
+	for _, v := range []string{"", "*ci", "ci:*:x"} {
+		if err := (&UserMatcher{Groups: []string{v}}).Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", v)
+		}
+	}
+}
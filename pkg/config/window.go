@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring period of the week during which a mixed rule is in
+// effect. Outside of all its windows a rule behaves as if mixed were false.
+type Window struct {
+	// Name identifies the window in metrics. It is required and must be
+	// unique among the windows of a rule, namespace or the global settings.
+	Name string `json:"name"`
+	// Weekdays the window starts on, such as "mon" or "Saturday". Empty
+	// means every day.
+	Weekdays []string `json:"weekdays,omitempty"`
+	// Start and End are "HH:MM" times of day. A window whose end is before
+	// its start runs past midnight into the next day. Both empty, or equal,
+	// means the whole day.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Timezone is an IANA time zone name. It defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// Compile parses the window. It must be called before Active.
+func (w *Window) Compile() error {
+	var err error
+	w.location = time.UTC
+	if w.Timezone != "" {
+		if w.location, err = time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("window %q: %w", w.Name, err)
+		}
+	}
+
+	w.days = nil
+	if len(w.Weekdays) > 0 {
+		w.days = map[time.Weekday]bool{}
+	}
+	for _, d := range w.Weekdays {
+		var day time.Weekday
+		ok := len(d) >= 3
+		if ok {
+			day, ok = weekdays[strings.ToLower(d[:3])]
+		}
+		if !ok {
+			return fmt.Errorf("window %q: unknown weekday %q", w.Name, d)
+		}
+		w.days[day] = true
+	}
+
+	if (w.Start == "") != (w.End == "") {
+		return fmt.Errorf("window %q: start and end must be set together", w.Name)
+	}
+	if w.start, err = parseMinuteOfDay(w.Start); err != nil {
+		return fmt.Errorf("window %q: start: %w", w.Name, err)
+	}
+	if w.end, err = parseMinuteOfDay(w.End); err != nil {
+		return fmt.Errorf("window %q: end: %w", w.Name, err)
+	}
+	return nil
+}
+
+// Active reports whether t falls inside the window.
+func (w *Window) Active(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case w.start == w.end:
+		return w.on(day)
+	case w.start < w.end:
+		return w.on(day) && minute >= w.start && minute < w.end
+	case minute >= w.start:
+		return w.on(day)
+	case minute < w.end:
+		// The part after midnight belongs to the window of the day before.
+		return w.on((day + 6) % 7)
+	}
+	return false
+}
+
+func (w *Window) on(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+// parseMinuteOfDay parses "HH:MM", allowing "24:00" as the end of the day.
+func parseMinuteOfDay(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return (hour*60 + minute) % (24 * 60), nil
+}
+
+// ActiveWindow returns the first of windows that contains t. A rule without
+// windows is always in effect, which is reported as ok with a nil window.
+func ActiveWindow(windows []*Window, t time.Time) (window *Window, ok bool) {
+	if len(windows) == 0 {
+		return nil, true
+	}
+	for _, w := range windows {
+		if w.Active(t) {
+			return w, true
+		}
+	}
+	return nil, false
+}
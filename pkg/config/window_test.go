@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowActive(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2022-08-01 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2022, 8, day, hour, minute, 0, 0, shanghai)
+	}
+
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		active bool
+	}{
+		{"whole day", Window{}, at(1, 12, 0), true},
+		{"inside", Window{Start: "09:00", End: "18:00", Timezone: "Asia/Shanghai"}, at(1, 9, 0), true},
+		{"end is exclusive", Window{Start: "09:00", End: "18:00", Timezone: "Asia/Shanghai"}, at(1, 18, 0), false},
+		{"utc by default", Window{Start: "09:00", End: "18:00"}, at(1, 9, 0), false},
+		{"night before midnight", Window{Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}, at(1, 23, 30), true},
+		{"night after midnight", Window{Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}, at(2, 5, 59), true},
+		{"night daytime", Window{Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}, at(2, 12, 0), false},
+		{"weekday", Window{Weekdays: []string{"Mon"}, Timezone: "Asia/Shanghai"}, at(1, 12, 0), true},
+		{"other weekday", Window{Weekdays: []string{"tue", "wed"}, Timezone: "Asia/Shanghai"}, at(1, 12, 0), false},
+		{"night continues into next day", Window{Weekdays: []string{"friday"}, Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}, at(6, 3, 0), true},
+		{"night starts on listed day only", Window{Weekdays: []string{"friday"}, Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}, at(5, 3, 0), false},
+		{"until end of day", Window{Start: "20:00", End: "24:00", Timezone: "Asia/Shanghai"}, at(1, 23, 59), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			if active := tt.window.Active(tt.t); active != tt.active {
+				t.Errorf("Active(%s) = %v, want %v", tt.t, active, tt.active)
+			}
+		})
+	}
+}
+
+func TestWindowCompileErrors(t *testing.T) {
+	for _, w := range []Window{
+		{Timezone: "Mars/Olympus"},
+		{Weekdays: []string{"someday"}},
+		{Start: "09:00"},
+		{Start: "25:00", End: "26:00"},
+		{Start: "9h", End: "18h"},
+	} {
+		if err := w.Compile(); err == nil {
+			t.Errorf("expected error for %+v", w)
+		}
+	}
+}
+
+func TestLoadFileRejectsInvalidWindow(t *testing.T) {
+	_, err := LoadFile(writeConfig(t, `{
+		"mixedreslist": [
+			{"namespace": "batch", "name": "job", "mixed": true, "windows": [{"start": "22:00"}]}
+		]
+	}`))
+	if err == nil {
+		t.Fatal("expected invalid window to be rejected")
+	}
+}
@@ -17,17 +17,22 @@ limitations under the License.
 package admission
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
 )
 
-type patchOperation struct {
+// PatchOperation is a single JSON Patch (RFC 6902) operation.
+type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
 	Value interface{} `json:"value,omitempty"`
 }
 
@@ -38,6 +43,9 @@ const (
 	ContainerResourceMemoryKey   string = "cmos.mixed/memory"
 	ContainerResourcePodCountKey string = "cmos.mixed/podcount"
 	PodNodeSelectorKey           string = "cmos/mixed-schedule"
+	// PodAnnotationOriginalResourcesKey records the native cpu and memory
+	// requests and limits of the containers of a mixed template.
+	PodAnnotationOriginalResourcesKey string = "hc/original-resources"
 	// PodNodeSelectorLable string = `[
 	//      { "op": "add", "path": "/spec/template/spec/nodeSelector", "value": {"cmos/mixed-schedule": "true"}}
 	//  ]`
@@ -68,141 +76,170 @@ const (
 // 	return &reviewResponse
 // }
 
-func mutatePodAnnotations(target map[string]string, added map[string]string) (patch []patchOperation) {
-	for key, value := range added {
-		if target == nil || target[key] == "" {
-			target = map[string]string{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/template/metadata/annotations",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-		} else {
-			patch = append(patch, patchOperation{
-				Op:    "replace",
-				Path:  "/spec/template/metadata/annotations/" + strings.Replace(key, "/", "~1", -1),
-				Value: value,
-			})
+// escapeJSONPointer escapes a map key for use in a JSON Patch path.
+func escapeJSONPointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// mutateMap returns the patch that sets the added keys in the string map at
+// path, leaving every other key of the map alone. The map is created if
+// target is nil.
+func mutateMap(path string, target map[string]string, added map[string]string) (patch []PatchOperation) {
+	if target == nil {
+		return []PatchOperation{{
+			Op:    "add",
+			Path:  path,
+			Value: added,
+		}}
+	}
+	keys := make([]string, 0, len(added))
+	for key := range added {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		op := "add"
+		if _, ok := target[key]; ok {
+			op = "replace"
 		}
+		patch = append(patch, PatchOperation{
+			Op:    op,
+			Path:  path + "/" + escapeJSONPointer(key),
+			Value: added[key],
+		})
 	}
 	return
 }
 
-func mutatePodLables(target map[string]string, added map[string]string) (patch []patchOperation) {
+func mutatePodAnnotations(target map[string]string, added map[string]string) (patch []PatchOperation) {
+	return mutateMap("/spec/template/metadata/annotations", target, added)
+}
+
+// mutatePodLables sets the added labels on the pod template. The selector of
+// a deployment is immutable, so it is never patched. A label that the
+// selector already holds, as earlier versions of the webhook put it there,
+// keeps the selector's value, which the template labels must match.
+func mutatePodLables(deployment *appsv1.Deployment, added map[string]string) (patch []PatchOperation) {
+	labels := map[string]string{}
 	for key, value := range added {
-		if target == nil || target[key] == "" {
-			target = map[string]string{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/selector/matchLabels",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/template/metadata/labels",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-		} else {
-			patch = append(patch, patchOperation{
-				Op:    "replace",
-				Path:  "/spec/selector/matchLabels/" + strings.Replace(key, "/", "~1", -1),
-				Value: value,
-			})
-			patch = append(patch, patchOperation{
-				Op:    "replace",
-				Path:  "/spec/template/metadata/labels/" + strings.Replace(key, "/", "~1", -1),
-				Value: value,
-			})
+		if pinned, ok := selectorLabel(deployment, key); ok {
+			value = pinned
 		}
+		labels[key] = value
 	}
-	return
+	return mutateMap("/spec/template/metadata/labels", deployment.Spec.Template.Labels, labels)
 }
 
-func mutateContainerResource(deployment *appsv1.Deployment) (patch []patchOperation) {
-	containers := deployment.Spec.Template.Spec.Containers
-	for index, container := range containers {
-		reqs := container.Resources.Requests
-		lims := container.Resources.Limits
-
-		patch = append(patch, patchOperation{
-			Op:   "add",
-			Path: fmt.Sprintf("/spec/template/spec/containers/%d/resources/limits", index),
-			Value: map[string]resource.Quantity{
-				ContainerResourceCpuKey: lims[corev1.ResourceCPU],
-			},
-		})
-		patch = append(patch, patchOperation{
-			Op:   "add",
-			Path: fmt.Sprintf("/spec/template/spec/containers/%d/resources/limits", index),
-			Value: map[string]resource.Quantity{
-				ContainerResourceMemoryKey: lims[corev1.ResourceMemory],
-			},
-		})
+// selectorLabel returns the value the selector of deployment requires for
+// the label key, if it requires one.
+func selectorLabel(deployment *appsv1.Deployment, key string) (string, bool) {
+	if deployment.Spec.Selector == nil {
+		return "", false
+	}
+	value, ok := deployment.Spec.Selector.MatchLabels[key]
+	return value, ok
+}
 
-		patch = append(patch, patchOperation{
-			Op:   "add",
-			Path: fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests", index),
-			Value: map[string]resource.Quantity{
-				ContainerResourceCpuKey: reqs[corev1.ResourceCPU],
-			},
-		})
-		patch = append(patch, patchOperation{
-			Op:   "add",
-			Path: fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests", index),
-			Value: map[string]resource.Quantity{
-				ContainerResourceMemoryKey: reqs[corev1.ResourceMemory],
-			},
-		})
+// originalResources returns the native resources recorded on a template by
+// an earlier mixed mutation, keyed by container name.
+func originalResources(template *corev1.PodTemplateSpec) map[string]corev1.ResourceRequirements {
+	original := map[string]corev1.ResourceRequirements{}
+	if value, ok := template.Annotations[PodAnnotationOriginalResourcesKey]; ok {
+		// A broken annotation is treated as absent.
+		_ = json.Unmarshal([]byte(value), &original)
+	}
+	return original
+}
 
-		// add cmos.mixed/podcount
-		patch = append(patch, patchOperation{
-			Op:   "add",
-			Path: fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests", index),
-			Value: map[string]string{
-				ContainerResourcePodCountKey: "1",
-			},
-		})
+// nativeResources returns the cpu and memory requests and limits a
+// container has, or had before it was made mixed.
+func nativeResources(c corev1.Container, original map[string]corev1.ResourceRequirements) corev1.ResourceRequirements {
+	native := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, name := range nativeResourceNames {
+		if q, ok := c.Resources.Requests[name]; ok {
+			native.Requests[name] = q
+		} else if q, ok := original[c.Name].Requests[name]; ok {
+			native.Requests[name] = q
+		}
+		if q, ok := c.Resources.Limits[name]; ok {
+			native.Limits[name] = q
+		} else if q, ok := original[c.Name].Limits[name]; ok {
+			native.Limits[name] = q
+		}
+	}
+	return native
+}
 
-		// // replace requests.cpu requests.memory
-		// patch = append(patch, patchOperation{
-		// 	Op:    "replace",
-		// 	Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests/cpu", index),
-		// 	Value: 0,
-		// })
-		// patch = append(patch, patchOperation{
-		// 	Op:    "replace",
-		// 	Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests/memory", index),
-		// 	Value: 0,
-		// })
+// recordOriginalResources returns the annotation value that records the
+// native resources of every selected container, so that they can be restored
+// when the workload leaves mixed mode.
+func recordOriginalResources(deployment *appsv1.Deployment, selector *config.ContainerSelector) string {
+	original := originalResources(&deployment.Spec.Template)
+	record := map[string]corev1.ResourceRequirements{}
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		if !selector.Selected(c.Name) {
+			continue
+		}
+		record[c.Name] = nativeResources(c, original)
+	}
+	value, _ := json.Marshal(record)
+	return string(value)
+}
 
+// replaceResources returns a copy of list without the removed resources and
+// with the added ones.
+func replaceResources(list corev1.ResourceList, removed []corev1.ResourceName, added corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for name, q := range list {
+		result[name] = q
 	}
-	return
+	for _, name := range removed {
+		delete(result, name)
+	}
+	for name, q := range added {
+		result[name] = q
+	}
+	return result
 }
 
-func mutateNodeSelectol(target map[string]string, added map[string]string) (patch []patchOperation) {
-	for key, value := range added {
-		if target == nil || target[key] == "" {
-			target = map[string]string{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/spec/template/spec/nodeSelector",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-		} else {
-			patch = append(patch, patchOperation{
-				Op:    "replace",
-				Path:  "/spec/template/spec/nodeSelector/" + strings.Replace(key, "/", "~1", -1),
-				Value: value,
-			})
+// mutateContainerResource moves the native cpu and memory requests and
+// limits of every container to the cmos.mixed extended resources, so that
+// the pod only asks the default scheduler for a cmos.mixed/podcount slot.
+// Other resources are kept. Containers the selector leaves out keep, or get
+// back, their native resources. Containers without some of the native values
+// are handled as the missing resources policy says.
+func mutateContainerResource(deployment *appsv1.Deployment, selector *config.ContainerSelector, policy *config.MissingResources, conv *config.Conversion) (patch []PatchOperation) {
+	original := originalResources(&deployment.Spec.Template)
+	for index, container := range deployment.Spec.Template.Spec.Containers {
+		if !selector.Selected(container.Name) {
+			if hasMixedResources(container) {
+				patch = append(patch, restoreContainerResources(index, container, original)...)
+			}
+			continue
 		}
+		resources, ok := completeResources(nativeResources(container, original), policy)
+		if !ok {
+			continue
+		}
+		mirrored := mirroredResources(resources, conv)
+
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests", index),
+			Value: replaceResources(container.Resources.Requests, nativeResourceNames, mirrored),
+		})
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/limits", index),
+			Value: replaceResources(container.Resources.Limits, nativeResourceNames, mirrored),
+		})
 	}
 	return
 }
+
+func mutateNodeSelectol(target map[string]string, added map[string]string) (patch []PatchOperation) {
+	return mutateMap("/spec/template/spec/nodeSelector", target, added)
+}
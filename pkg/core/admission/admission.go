@@ -2,45 +2,99 @@ package admission
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/klog"
 
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/chilog"
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/cluster"
 )
 
 type API struct {
-	// Protect against config, template and http client
-	mtx sync.RWMutex
-
-	conf   *config.Config
+	// conf holds the current *config.Config. Every admission request loads it
+	// exactly once and works on that snapshot, so a concurrent reload can
+	// never change the rules in the middle of a request.
+	conf   atomic.Value
 	logger log.Logger
+
+	// cluster is nil when the webhook runs without informers. Checks that
+	// need cluster state are skipped then.
+	cluster *cluster.Cluster
+
+	// killSwitch holds the *config.KillSwitch set through the lifecycle
+	// API. Writers hold killSwitchMtx.
+	killSwitch    atomic.Value
+	killSwitchMtx sync.Mutex
+	// killed counts the requests a kill switch let through unchanged.
+	killed *prometheus.CounterVec
+
+	// audited counts the requests of rules in audit mode.
+	audited *prometheus.CounterVec
+
+	// failures counts the requests answered by the failure policy.
+	failures *prometheus.CounterVec
+
+	// events is nil when no events are recorded.
+	events EventRecorder
+
+	// now returns the admission time. Tests replace it to evaluate
+	// schedule windows at a fixed time.
+	now func() time.Time
 }
 
-func NewAPI(logger log.Logger) *API {
+func NewAPI(logger log.Logger, cluster *cluster.Cluster) *API {
 	return &API{
-		logger: logger,
+		logger:   logger,
+		cluster:  cluster,
+		failures: newFailuresCounter(),
+		killed:   newKilledCounter(),
+		audited:  newAuditedCounter(),
+		now:      time.Now,
 	}
 }
 
-func (api *API) Update(conf *config.Config) {
-	api.mtx.Lock()
-	defer api.mtx.Unlock()
+// Update makes conf the configuration of all following requests. conf is
+// validated and compiled first, so that configurations built in code are
+// safe to use. An invalid configuration, or one naming unknown mutators, is
+// rejected.
+func (api *API) Update(conf *config.Config) error {
+	if conf == nil {
+		conf = &config.Config{}
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	if err := validateMutators(conf); err != nil {
+		return err
+	}
+	api.conf.Store(conf)
+	return nil
+}
 
-	api.conf = conf
+// snapshot returns the configuration currently in effect. Before the first
+// Update it returns an empty configuration, which matches no workloads.
+func (api *API) snapshot() *config.Config {
+	if conf, ok := api.conf.Load().(*config.Config); ok {
+		return conf
+	}
+	return &config.Config{}
 }
 
 func (api *API) Routes() chi.Router {
@@ -49,17 +103,14 @@ func (api *API) Routes() chi.Router {
 	router.Use(middleware.RequestLogger(&chilog.KitLogger{Logger: api.logger}))
 	router.Use(middleware.Recoverer)
 	router.HandleFunc("/mutate", api.serveMutate)
+	router.Get("/debug/rules", api.serveRules)
 	// router.HandleFunc("/testconfig", api.getLimitList())
 	return router
 }
 
-func (api *API) getRequiredList() (requirements []string) {
+func (api *API) getRequiredList(conf *config.Config) (requirements []string) {
 	logger := log.With(api.logger, "admission", "getlimitlist")
 
-	api.mtx.RLock()
-	conf := api.conf
-	api.mtx.RUnlock()
-
 	for _, v := range conf.Mixedreslist {
 		requirements = append(requirements, v.Name+"/"+v.Namespace)
 	}
@@ -80,6 +131,10 @@ func toAdmissionResponse(err error) *admissionv1.AdmissionResponse {
 // admitFunc is the type we use for all of our validators and mutators
 type admitFunc func(admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
 
+// configAdmitFunc is an admitFunc that is handed the configuration snapshot
+// to answer the request with.
+type configAdmitFunc func(*config.Config, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
+
 // serve handles the http portion of a request prior to handing to an admit
 // function
 func (api *API) serve(w http.ResponseWriter, r *http.Request, admit admitFunc) {
@@ -117,14 +172,15 @@ func (api *API) serve(w http.ResponseWriter, r *http.Request, admit admitFunc) {
 	if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
 		// klog.Error(err)
 		level.Error(logger).Log("err", err)
-		responseAdmissionReview.Response = toAdmissionResponse(err)
+		responseAdmissionReview.Response = api.fail(api.snapshot(), config.FailureDecode, http.StatusBadRequest, err)
+	} else if requestedAdmissionReview.Request == nil {
+		responseAdmissionReview.Response = api.fail(api.snapshot(), config.FailureDecode, http.StatusBadRequest, errors.New("admission review without a request"))
 	} else {
 		responseAdmissionReview.Response = admit(requestedAdmissionReview)
+		// Return the same UID
+		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 	}
 
-	// Return the same UID
-	responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
-
 	// klog.V(2).Info(fmt.Sprintf("sending response: %v", responseAdmissionReview.Response))
 	level.Info(logger).Log("msg", fmt.Sprintf("sending response: %v", responseAdmissionReview.Response))
 
@@ -140,12 +196,25 @@ func (api *API) serve(w http.ResponseWriter, r *http.Request, admit admitFunc) {
 }
 
 func (api *API) serveMutate(w http.ResponseWriter, r *http.Request) {
-	api.serve(w, r, api.mutate)
+	api.serve(w, r, api.guard(api.mutateWith))
 }
 
 func (api *API) mutate(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return api.mutateWith(api.snapshot(), ar)
+}
+
+// mutateWith answers the request under conf, the configuration snapshot the
+// whole request is handled with.
+func (api *API) mutateWith(conf *config.Config, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	logger := log.With(api.logger, "admission", "mutate")
 	req := ar.Request
+	if source := api.killedBy(conf, req.Namespace); source != "" {
+		level.Info(logger).Log("msg", fmt.Sprintf("kill switch engaged for namespace %s, allowed without changes", req.Namespace), "source", source)
+		api.killed.WithLabelValues(source).Inc()
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
 	var deployment appsv1.Deployment
 	var objectMeta *metav1.ObjectMeta
 	level.Info(logger).Log("msg", fmt.Sprintf("AdmissionReview for Kind=%s, Namespace=%s Name=%s UID=%s", req.Kind.Kind, req.Namespace, req.Name, req.UID))
@@ -154,70 +223,164 @@ func (api *API) mutate(ar admissionv1.AdmissionReview) *admissionv1.AdmissionRes
 	case "Deployment":
 		if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
 			level.Error(logger).Log("msg", "can't not unmarshal raw object", "err", err)
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Code:    http.StatusBadRequest,
-					Message: err.Error(),
-				},
-			}
-
+			return api.fail(conf, config.FailureDecode, http.StatusBadRequest, err)
 		}
 		objectMeta = &deployment.ObjectMeta
 	default:
+		return api.fail(conf, config.FailureUnsupportedKind, http.StatusBadRequest, fmt.Errorf("can't handle the kind(%s) object", req.Kind.Kind))
+	}
+	rule, required, ignored := api.mutationRequired(conf, objectMeta)
+	if required && !rule.AppliesTo(req.Kind.Kind, string(req.Operation)) {
+		level.Info(logger).Log("msg", fmt.Sprintf("the rule for %s/%s does not apply to %s of a %s, allowed without changes", objectMeta.Name, objectMeta.Namespace, req.Operation, req.Kind.Kind))
+		api.event(req, &deployment, corev1.EventTypeNormal, EventReasonSkipped, "The mixed rule does not apply to %s requests", req.Operation)
 		return &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Code:    http.StatusBadRequest,
-				Message: fmt.Sprintf("can't handle the kind(%s) object", req.Kind.Kind),
-			},
+			Allowed: true,
 		}
 	}
-	index, required := api.mutationRequired(objectMeta)
-	if !required {
+	if required && !rule.Users.Selected(req.UserInfo.Username, req.UserInfo.Groups) {
+		level.Info(logger).Log("msg", fmt.Sprintf("the rule for %s/%s does not apply to requests of %s, allowed without changes", objectMeta.Name, objectMeta.Namespace, req.UserInfo.Username))
+		api.event(req, &deployment, corev1.EventTypeNormal, EventReasonSkipped, "The mixed rule does not apply to requests of %s", req.UserInfo.Username)
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
-	mixed := api.conf.Mixedreslist[index].Mixed
+	var resp *admissionv1.AdmissionResponse
+	if required && rule.Audit {
+		// What an audited rule would do is recorded as a single event.
+		resp = api.audit(req, &deployment, api.admit(conf, req, rule, required, &deployment, discardEvent))
+	} else {
+		resp = api.admit(conf, req, rule, required, &deployment, func(eventtype, reason, messageFmt string, args ...interface{}) {
+			api.event(req, &deployment, eventtype, reason, messageFmt, args...)
+		})
+	}
+	for _, err := range ignored {
+		// Invalid annotations are reported to whoever applies the workload,
+		// not only logged.
+		resp.Warnings = append(resp.Warnings, err.Error()+", ignored")
+		api.event(req, &deployment, corev1.EventTypeWarning, EventReasonSkipped, "%s, ignored", err)
+	}
+	return resp
 
+	// return addLabel(ar)
+}
+
+// admit answers a request for a workload that passed the filters of its
+// rule, or has no rule if required is false. record records events about
+// the workload.
+func (api *API) admit(conf *config.Config, req *admissionv1.AdmissionRequest, rule *config.MixedRes, required bool, deployment *appsv1.Deployment, record eventFunc) *admissionv1.AdmissionResponse {
+	logger := log.With(api.logger, "admission", "mutate")
+	if required && !api.conditionsMet(rule, req, deployment) {
+		level.Info(logger).Log("msg", fmt.Sprintf("conditions of the rule for %s/%s are not met", deployment.Name, deployment.Namespace))
+		record(corev1.EventTypeNormal, EventReasonSkipped, "The conditions of the mixed rule are not met")
+		required = false
+	}
+	if !required {
+		if req.Operation == admissionv1.Update && wasMixed(&deployment.Spec.Template) {
+			level.Info(logger).Log("msg", fmt.Sprintf("no rule applies to %s/%s any more, removing mixed markers", deployment.Name, deployment.Namespace))
+			record(corev1.EventTypeNormal, EventReasonUnmixed, "No mixed rule applies any more, mixed resources and markers removed")
+			return api.patchResponse(conf, unmutateMixed(deployment, false), nil)
+		}
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	var warnings []string
+	if rule.Mixed {
+		if reason := eligibilityReason(rule, deployment); reason != "" {
+			level.Info(logger).Log("msg", reason)
+			rule = withSource(rule, "mixed", config.LayerEligibility)
+			rule.Mixed = false
+			warnings = append(warnings, reason+", admitted as non-mixed")
+			record(corev1.EventTypeWarning, EventReasonFallback, "%s, admitted as non-mixed", reason)
+		}
+	}
+	if rule.Mixed {
+		if reason := missingResourcesReason(rule, deployment); reason != "" {
+			level.Info(logger).Log("msg", reason)
+			record(corev1.EventTypeWarning, EventReasonDenied, "%s", reason)
+			return &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: reason,
+				},
+			}
+		}
+		if action, reason := api.checkQuota(conf, rule, deployment); reason != "" {
+			level.Info(logger).Log("msg", reason, "action", action)
+			if action == config.QuotaActionDeny {
+				record(corev1.EventTypeWarning, EventReasonDenied, "%s", reason)
+				return &admissionv1.AdmissionResponse{
+					Result: &metav1.Status{
+						Code:    http.StatusForbidden,
+						Message: reason,
+					},
+				}
+			}
+			rule = withSource(rule, "mixed", config.LayerQuota)
+			rule.Mixed = false
+			warnings = append(warnings, reason+", admitted as non-mixed")
+			record(corev1.EventTypeWarning, EventReasonFallback, "%s, admitted as non-mixed", reason)
+		}
+	}
 	// 执行操作
-	podAnnotations := map[string]string{
-		PodAnnotationPriorityKey: strconv.FormatInt(api.conf.Mixedreslist[index].Priority, 10),
+	patch, mutated, err := runMutators(&Object{Request: req, Rule: rule, Config: conf, Deployment: deployment})
+	if err != nil {
+		return api.fail(conf, config.FailureMutator, http.StatusInternalServerError, err)
 	}
-	podLabels := map[string]string{
-		PodLabelMixedKey: strconv.FormatBool(mixed),
+	if rule.Mixed && rule.Feasibility.EffectiveAction() != config.FeasibilityIgnore {
+		if reason := api.feasibilityReason(mutated); reason != "" {
+			action := rule.Feasibility.EffectiveAction()
+			level.Info(logger).Log("msg", reason, "action", action)
+			if action == config.FeasibilityFallback {
+				rule = withSource(rule, "mixed", config.LayerFeasibility)
+				rule.Mixed = false
+				if patch, mutated, err = runMutators(&Object{Request: req, Rule: rule, Config: conf, Deployment: deployment}); err != nil {
+					return api.fail(conf, config.FailureMutator, http.StatusInternalServerError, err)
+				}
+				warnings = append(warnings, reason+", admitted as non-mixed")
+				record(corev1.EventTypeWarning, EventReasonFallback, "%s, admitted as non-mixed", reason)
+			} else {
+				warnings = append(warnings, reason)
+				record(corev1.EventTypeWarning, EventReasonUnschedulable, "%s", reason)
+			}
+		}
 	}
-	nodeSelectolLabels := map[string]string{
-		PodNodeSelectorKey: "true",
+	if rule.Mixed {
+		if reason := guardrailsReason(rule, mutated); reason != "" {
+			level.Info(logger).Log("msg", reason)
+			record(corev1.EventTypeWarning, EventReasonDenied, "%s", reason)
+			return &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: reason,
+				},
+			}
+		}
+		record(corev1.EventTypeNormal, EventReasonMixed, "Made mixed with priority %d", rule.Priority)
 	}
-	var patch []patchOperation
-	patch = append(patch, mutatePodAnnotations(deployment.Spec.Template.ObjectMeta.Annotations, podAnnotations)...)
-	patch = append(patch, mutatePodLables(deployment.Spec.Template.ObjectMeta.Labels, podLabels)...)
-
-	// pod := deployment.Spec.Template.Spec
-	if mixed {
-		patch = append(patch, mutateNodeSelectol(deployment.Spec.Template.Spec.NodeSelector, nodeSelectolLabels)...)
-		patch = append(patch, mutateContainerResource(&deployment)...)
+	return api.patchResponse(conf, patch, warnings)
+}
 
-	}
+// patchResponse returns the response that admits the object with patch
+// applied.
+func (api *API) patchResponse(conf *config.Config, patch []PatchOperation, warnings []string) *admissionv1.AdmissionResponse {
+	logger := log.With(api.logger, "admission", "mutate")
 	level.Info(logger).Log("msg", fmt.Sprintf("Patch=%s", patch))
 	patchBytes, err := json.Marshal(patch)
 	if err != nil {
-		klog.Errorf("patch marshal error: %v", err)
-		return &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Code:    http.StatusBadRequest,
-				Message: err.Error(),
-			},
-		}
+		return api.fail(conf, config.FailurePatchMarshal, http.StatusInternalServerError, fmt.Errorf("patch marshal error: %w", err))
 	}
-	return &admissionv1.AdmissionResponse{
+	resp := &admissionv1.AdmissionResponse{
 		Allowed: true,
 		Patch:   patchBytes,
 		PatchType: func() *admissionv1.PatchType {
 			pt := admissionv1.PatchTypeJSONPatch
 			return &pt
 		}(),
+		Warnings: warnings,
 	}
-
-	// return addLabel(ar)
+	if len(warnings) > 0 {
+		resp.Result = &metav1.Status{Message: strings.Join(warnings, "; ")}
+	}
+	return resp
 }
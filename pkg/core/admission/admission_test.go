@@ -0,0 +1,234 @@
+package admission
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func newTestDeployment(namespace, name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  name,
+						Image: "nginx:1.21",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("1"),
+								corev1.ResourceMemory: resource.MustParse("512Mi"),
+							},
+							Limits: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("2"),
+								corev1.ResourceMemory: resource.MustParse("1Gi"),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func newTestReview(t *testing.T, operation admissionv1.Operation, obj *appsv1.Deployment) admissionv1.AdmissionReview {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+// applyResponse applies the patch of resp to obj and returns the result.
+func applyResponse(t *testing.T, obj *appsv1.Deployment, resp *admissionv1.AdmissionResponse) *appsv1.Deployment {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Patch) > 0 {
+		patch, err := jsonpatch.DecodePatch(resp.Patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err = patch.Apply(raw); err != nil {
+			t.Fatalf("applying %s: %v", resp.Patch, err)
+		}
+	}
+	patched := &appsv1.Deployment{}
+	if err := json.Unmarshal(raw, patched); err != nil {
+		t.Fatal(err)
+	}
+	return patched
+}
+
+func TestMutateWithoutConfig(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+
+	resp := api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx")))
+	if !resp.Allowed {
+		t.Fatalf("expected request to be allowed, got %v", resp.Result)
+	}
+	if len(resp.Patch) != 0 {
+		t.Fatalf("expected no patch, got %s", resp.Patch)
+	}
+}
+
+func TestMutateUsesMatchedRule(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "other", Mixed: false, Priority: 1},
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 102},
+	}})
+
+	d := newTestDeployment("default", "nginx")
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed {
+		t.Fatalf("expected request to be allowed, got %v", resp.Result)
+	}
+
+	patched := applyResponse(t, d, resp)
+	if v := patched.Spec.Template.Annotations[PodAnnotationPriorityKey]; v != "102" {
+		t.Errorf("priority annotation = %q, want 102", v)
+	}
+	if v := patched.Spec.Template.Spec.NodeSelector[PodNodeSelectorKey]; v != "true" {
+		t.Errorf("node selector = %q, want true", v)
+	}
+}
+
+func TestMutateRuleFilters(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Operations: []string{config.OperationCreate}},
+		{Namespace: "default", Name: "job", Mixed: true, Priority: 10, Kinds: []string{"StatefulSet"}},
+	}})
+
+	d := newTestDeployment("default", "nginx")
+	mixed := applyResponse(t, d, api.mutate(newTestReview(t, admissionv1.Create, d)))
+	if !wasMixed(&mixed.Spec.Template) {
+		t.Fatalf("expected the workload to be mixed on create")
+	}
+
+	// A later edit is left alone, even one that removes the mixed markers.
+	edited := mixed.DeepCopy()
+	delete(edited.Spec.Template.Spec.NodeSelector, PodNodeSelectorKey)
+	for _, obj := range []*appsv1.Deployment{mixed, edited} {
+		resp := api.mutate(newTestReview(t, admissionv1.Update, obj))
+		if !resp.Allowed || len(resp.Patch) != 0 {
+			t.Errorf("expected update to be allowed without a patch, got %v %s", resp.Result, resp.Patch)
+		}
+	}
+
+	resp := api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment("default", "job")))
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Errorf("expected a deployment to be allowed without a patch by a StatefulSet rule, got %v %s", resp.Result, resp.Patch)
+	}
+}
+
+func TestMutateUserFilter(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: true, Priority: 10,
+		Users: &config.UserSelector{
+			Include: &config.UserMatcher{ServiceAccounts: []string{"ci:*"}},
+			Exclude: &config.UserMatcher{Groups: []string{"sre-break-glass"}},
+		},
+	}}})
+
+	for _, tt := range []struct {
+		user    authenticationv1.UserInfo
+		mutated bool
+	}{
+		{authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer"}, true},
+		{authenticationv1.UserInfo{Username: "alice"}, false},
+		{authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer", Groups: []string{"sre-break-glass"}}, false},
+	} {
+		review := newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx"))
+		review.Request.UserInfo = tt.user
+		resp := api.mutate(review)
+		if !resp.Allowed || (len(resp.Patch) > 0) != tt.mutated {
+			t.Errorf("%+v: allowed = %v, patch = %s, want mutated %v", tt.user, resp.Allowed, resp.Patch, tt.mutated)
+		}
+	}
+}
+
+// TestMutateConcurrentReload exercises mutate while the configuration is being
+// swapped between lists of different lengths. Run with -race.
+func TestMutateConcurrentReload(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+
+	long := &config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "a", Mixed: false, Priority: 1},
+		{Namespace: "default", Name: "b", Mixed: false, Priority: 2},
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 3},
+	}}
+	short := &config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: false, Priority: 4},
+	}}
+
+	var reloader, mutators sync.WaitGroup
+	stop := make(chan struct{})
+	reloader.Add(1)
+	go func() {
+		defer reloader.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				api.Update(long)
+			} else {
+				api.Update(short)
+			}
+		}
+	}()
+
+	review := newTestReview(t, admissionv1.Update, newTestDeployment("default", "nginx"))
+	for i := 0; i < 4; i++ {
+		mutators.Add(1)
+		go func() {
+			defer mutators.Done()
+			for j := 0; j < 200; j++ {
+				if resp := api.mutate(review); !resp.Allowed {
+					t.Errorf("expected request to be allowed, got %v", resp.Result)
+					return
+				}
+			}
+		}()
+	}
+
+	mutators.Wait()
+	close(stop)
+	reloader.Wait()
+}
@@ -0,0 +1,57 @@
+package admission
+
+import (
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Outcomes of an audited request, as recorded in the outcome audit
+// annotation and the audit metrics.
+const (
+	AuditOutcomePatch     = "patch"
+	AuditOutcomeDeny      = "deny"
+	AuditOutcomeUnchanged = "unchanged"
+)
+
+// Keys of the audit annotations of an audited request. The API server
+// prefixes them with the name of the webhook.
+const (
+	AuditAnnotationOutcomeKey = "outcome"
+	AuditAnnotationPatchKey   = "patch"
+	AuditAnnotationReasonKey  = "reason"
+)
+
+// audit turns resp, the answer to a request whose rule is in audit mode,
+// into one that admits the object unchanged. What resp would have done is
+// logged, counted, recorded as an event and kept in audit annotations.
+func (api *API) audit(req *admissionv1.AdmissionRequest, deployment *appsv1.Deployment, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	logger := log.With(api.logger, "admission", "audit")
+	annotations := map[string]string{}
+	var outcome, message string
+	switch {
+	case !resp.Allowed:
+		outcome, message = AuditOutcomeDeny, "would have been denied: "+resp.Result.Message
+		annotations[AuditAnnotationReasonKey] = resp.Result.Message
+	case len(resp.Patch) > 0 && string(resp.Patch) != "null" && string(resp.Patch) != "[]":
+		outcome, message = AuditOutcomePatch, fmt.Sprintf("would have been patched with %s", resp.Patch)
+		annotations[AuditAnnotationPatchKey] = string(resp.Patch)
+	default:
+		outcome, message = AuditOutcomeUnchanged, "would have been admitted unchanged"
+	}
+	annotations[AuditAnnotationOutcomeKey] = outcome
+
+	level.Info(logger).Log("msg", fmt.Sprintf("audit mode, %s/%s %s", deployment.Namespace, deployment.Name, message), "outcome", outcome)
+	api.audited.WithLabelValues(outcome).Inc()
+	api.event(req, deployment, corev1.EventTypeNormal, EventReasonAudit, "Audit mode, the workload %s", message)
+
+	return &admissionv1.AdmissionResponse{
+		Allowed:          true,
+		Warnings:         append(resp.Warnings, fmt.Sprintf("audit mode, the mixed rule was not applied: the workload %s", message)),
+		AuditAnnotations: annotations,
+	}
+}
@@ -0,0 +1,59 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/tools/record"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateAudit(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.SetEventRecorder(recorder)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Audit: true},
+		{Namespace: "default", Name: "web", Mixed: true, Priority: 10, Audit: true, Guardrails: &config.Guardrails{DenyHostNetwork: true}},
+	}})
+
+	d := newTestDeployment("default", "nginx")
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Fatalf("expected an audited request to be allowed without a patch, got %v %s", resp.Result, resp.Patch)
+	}
+	if resp.AuditAnnotations[AuditAnnotationOutcomeKey] != AuditOutcomePatch {
+		t.Errorf("audit annotations = %v", resp.AuditAnnotations)
+	}
+	// The recorded patch is the one the rule would have applied.
+	wouldBe := applyResponse(t, d, &admissionv1.AdmissionResponse{Patch: []byte(resp.AuditAnnotations[AuditAnnotationPatchKey])})
+	if !wasMixed(&wouldBe.Spec.Template) {
+		t.Errorf("expected the recorded patch to make the workload mixed")
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Normal "+EventReasonAudit+" ") {
+		t.Errorf("expected an audit event, got %q", e)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected a single event for an audited request")
+	}
+
+	unsafe := newTestDeployment("default", "web")
+	unsafe.Spec.Template.Spec.HostNetwork = true
+	resp = api.mutate(newTestReview(t, admissionv1.Create, unsafe))
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Fatalf("expected an audited denial to be allowed without a patch, got %v %s", resp.Result, resp.Patch)
+	}
+	if resp.AuditAnnotations[AuditAnnotationOutcomeKey] != AuditOutcomeDeny || !strings.Contains(resp.AuditAnnotations[AuditAnnotationReasonKey], "hostNetwork") {
+		t.Errorf("audit annotations = %v", resp.AuditAnnotations)
+	}
+
+	for outcome, want := range map[string]float64{AuditOutcomePatch: 1, AuditOutcomeDeny: 1, AuditOutcomeUnchanged: 0} {
+		if got := testutil.ToFloat64(api.audited.WithLabelValues(outcome)); got != want {
+			t.Errorf("audited{outcome=%s} = %v, want %v", outcome, got, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package admission
+
+import (
+	"fmt"
+	"strconv"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+const (
+	annotationsMutatorName        = "annotations"
+	labelsMutatorName             = "labels"
+	nodeSelectorMutatorName       = "node-selector"
+	sidecarsMutatorName           = "sidecars"
+	containerResourcesMutatorName = "container-resources"
+	imageRewriteMutatorName       = "image-rewrite"
+	unmixMutatorName              = "unmix"
+	podOverlayMutatorName         = "pod-overlay"
+	patchesMutatorName            = "patches"
+)
+
+func init() {
+	RegisterMutator(annotationsMutator{})
+	RegisterMutator(labelsMutator{})
+	RegisterMutator(nodeSelectorMutator{})
+	RegisterMutator(sidecarsMutator{})
+	RegisterMutator(containerResourcesMutator{})
+	RegisterMutator(imageRewriteMutator{})
+	RegisterMutator(unmixMutator{})
+	RegisterMutator(podOverlayMutator{})
+	RegisterMutator(patchesMutator{})
+}
+
+// annotationsMutator sets the priority annotation of the pod template.
+type annotationsMutator struct{}
+
+func (annotationsMutator) Name() string { return annotationsMutatorName }
+
+func (annotationsMutator) Applies(*admissionv1.AdmissionRequest, *config.MixedRes) bool {
+	return true
+}
+
+func (annotationsMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	podAnnotations := map[string]string{
+		PodAnnotationPriorityKey: strconv.FormatInt(obj.Rule.Priority, 10),
+	}
+	return mutatePodAnnotations(obj.Deployment.Spec.Template.Annotations, podAnnotations), nil
+}
+
+// labelsMutator sets the mixed label of the pod template.
+type labelsMutator struct{}
+
+func (labelsMutator) Name() string { return labelsMutatorName }
+
+func (labelsMutator) Applies(*admissionv1.AdmissionRequest, *config.MixedRes) bool {
+	return true
+}
+
+func (labelsMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	podLabels := map[string]string{
+		PodLabelMixedKey: strconv.FormatBool(obj.Rule.Mixed),
+	}
+	return mutatePodLables(obj.Deployment, podLabels), nil
+}
+
+// nodeSelectorMutator pins mixed pods to the co-location nodes.
+type nodeSelectorMutator struct{}
+
+func (nodeSelectorMutator) Name() string { return nodeSelectorMutatorName }
+
+func (nodeSelectorMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return rule.Mixed
+}
+
+func (nodeSelectorMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	nodeSelectolLabels := map[string]string{
+		PodNodeSelectorKey: "true",
+	}
+	return mutateNodeSelectol(obj.Deployment.Spec.Template.Spec.NodeSelector, nodeSelectolLabels), nil
+}
+
+// sidecarsMutator injects the sidecars of the rule into mixed pods and
+// removes the ones the rule no longer names.
+type sidecarsMutator struct{}
+
+func (sidecarsMutator) Name() string { return sidecarsMutatorName }
+
+func (sidecarsMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return rule.Mixed
+}
+
+func (sidecarsMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	var sidecars []*corev1.Container
+	if len(obj.Rule.Sidecars) > 0 {
+		object, request, err := jsonValues(obj.Request, obj.Deployment)
+		if err != nil {
+			return nil, err
+		}
+		data := &config.PatchData{Object: object, Request: request, Rule: obj.Rule}
+		for _, name := range obj.Rule.Sidecars {
+			sidecar := obj.Config.Sidecar(name)
+			if sidecar == nil {
+				return nil, fmt.Errorf("unknown sidecar %q", name)
+			}
+			c, err := sidecar.Render(data)
+			if err != nil {
+				return nil, err
+			}
+			sidecars = append(sidecars, c)
+		}
+	}
+	return mutateSidecars(obj.Deployment, sidecars), nil
+}
+
+// containerResourcesMutator moves the container resources of mixed pods to
+// the cmos.mixed extended resources, recording the native ones first.
+type containerResourcesMutator struct{}
+
+func (containerResourcesMutator) Name() string { return containerResourcesMutatorName }
+
+func (containerResourcesMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return rule.Mixed
+}
+
+func (containerResourcesMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	d := obj.Deployment
+	podAnnotations := map[string]string{
+		PodAnnotationOriginalResourcesKey: recordOriginalResources(d, obj.Rule.Containers),
+	}
+	patch := mutatePodAnnotations(d.Spec.Template.Annotations, podAnnotations)
+	return append(patch, mutateContainerResource(d, obj.Rule.Containers, obj.Rule.MissingResources, obj.Config.Conversion)...), nil
+}
+
+// imageRewriteMutator points the images of mixed pods at the mirror
+// registries of the rule, and back at the original registries once the rule
+// no longer rewrites them.
+type imageRewriteMutator struct{}
+
+func (imageRewriteMutator) Name() string { return imageRewriteMutatorName }
+
+func (imageRewriteMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return rule.Mixed
+}
+
+func (imageRewriteMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	return mutateImages(obj.Deployment, obj.Rule.ImageRewrites), nil
+}
+
+// unmixMutator takes a workload whose rule is no longer mixed out of mixed
+// mode on update.
+type unmixMutator struct{}
+
+func (unmixMutator) Name() string { return unmixMutatorName }
+
+func (unmixMutator) Applies(req *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return !rule.Mixed && req.Operation == admissionv1.Update
+}
+
+func (unmixMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	if !wasMixed(&obj.Deployment.Spec.Template) {
+		return nil, nil
+	}
+	return unmutateMixed(obj.Deployment, true), nil
+}
+
+// podOverlayMutator merges the pod spec overlay of the rule into the pod
+// template of a mixed workload. It also runs without an overlay, to put back
+// what an earlier overlay changed.
+type podOverlayMutator struct{}
+
+func (podOverlayMutator) Name() string { return podOverlayMutatorName }
+
+func (podOverlayMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return rule.Mixed
+}
+
+func (podOverlayMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	return mutatePodOverlay(obj.Deployment, obj.Rule.PodOverlay)
+}
+
+// patchesMutator renders the patch templates of the rule.
+type patchesMutator struct{}
+
+func (patchesMutator) Name() string { return patchesMutatorName }
+
+func (patchesMutator) Applies(_ *admissionv1.AdmissionRequest, rule *config.MixedRes) bool {
+	return len(rule.Patches) > 0
+}
+
+func (patchesMutator) Mutate(obj *Object) (patch []PatchOperation, err error) {
+	object, request, err := jsonValues(obj.Request, obj.Deployment)
+	if err != nil {
+		return nil, err
+	}
+	data := &config.PatchData{Object: object, Request: request, Rule: obj.Rule}
+	for _, p := range obj.Rule.Patches {
+		path, from, value, err := p.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		patch = append(patch, PatchOperation{
+			Op:    p.Op,
+			Path:  path,
+			From:  from,
+			Value: value,
+		})
+	}
+	return patch, nil
+}
@@ -0,0 +1,68 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// toJSONValue returns v the way a condition sees it: decoded from its JSON
+// form into maps, lists and scalars.
+func toJSONValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// jsonValues returns the object and the request the way conditions and patch
+// templates see them.
+func jsonValues(req *admissionv1.AdmissionRequest, obj interface{}) (object, request interface{}, err error) {
+	if object, err = toJSONValue(obj); err != nil {
+		return nil, nil, err
+	}
+	// The raw objects are already available as object.
+	r := *req
+	r.Object, r.OldObject = runtime.RawExtension{}, runtime.RawExtension{}
+	if request, err = toJSONValue(r); err != nil {
+		return nil, nil, err
+	}
+	return object, request, nil
+}
+
+// conditionsMet evaluates the conditions of rule against the object and the
+// request. A condition that fails to evaluate counts as not met.
+func (api *API) conditionsMet(rule *config.MixedRes, req *admissionv1.AdmissionRequest, obj interface{}) bool {
+	if len(rule.Conditions) == 0 {
+		return true
+	}
+	logger := log.With(api.logger, "admission", "conditions", "namespace", req.Namespace, "name", req.Name)
+
+	object, request, err := jsonValues(req, obj)
+	if err != nil {
+		level.Error(logger).Log("msg", "converting object failed", "err", err)
+		return false
+	}
+
+	for _, cond := range rule.Conditions {
+		met, err := cond.Eval(object, request)
+		if err != nil {
+			level.Warn(logger).Log("msg", fmt.Sprintf("condition %q failed to evaluate", cond.Expression), "err", err)
+			return false
+		}
+		level.Debug(logger).Log("msg", "condition evaluated", "condition", cond.Expression, "result", met)
+		if !met {
+			return false
+		}
+	}
+	return true
+}
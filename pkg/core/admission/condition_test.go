@@ -0,0 +1,79 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateConditions(t *testing.T) {
+	conf := &config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: true, Priority: 1,
+		Conditions: []*config.Condition{
+			{Expression: `object.spec.replicas > 2`},
+			{Expression: `request.operation == "CREATE"`},
+			{Expression: `all(object.spec.template.spec.containers, c, !startsWith(c.image, "docker.io/"))`},
+		},
+	}}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+
+	tests := []struct {
+		name      string
+		replicas  int32
+		image     string
+		operation admissionv1.Operation
+		mutated   bool
+	}{
+		{"all met", 3, "nginx:1.21", admissionv1.Create, true},
+		{"too few replicas", 2, "nginx:1.21", admissionv1.Create, false},
+		{"update", 3, "nginx:1.21", admissionv1.Update, false},
+		{"public image", 3, "docker.io/library/nginx:1.21", admissionv1.Create, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDeployment("default", "nginx")
+			d.Spec.Replicas = &tt.replicas
+			d.Spec.Template.Spec.Containers[0].Image = tt.image
+			resp := api.mutate(newTestReview(t, tt.operation, d))
+			if !resp.Allowed {
+				t.Fatalf("expected request to be allowed, got %v", resp.Result)
+			}
+			if mutated := len(resp.Patch) > 0; mutated != tt.mutated {
+				t.Errorf("mutated = %v, want %v", mutated, tt.mutated)
+			}
+		})
+	}
+}
+
+func TestUpdateCompilesConditions(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	if err := api.Update(&config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: true, Priority: 1,
+		Conditions: []*config.Condition{{Expression: `object.spec.replicas >`}},
+	}}}); err == nil {
+		t.Fatal("expected an invalid condition to be rejected")
+	}
+
+	// A configuration built in code without Validate is compiled by Update.
+	if err := api.Update(&config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: true, Priority: 1,
+		Conditions: []*config.Condition{{Expression: `request.operation == "CREATE"`}},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDeployment("default", "nginx")
+	if !wasMixed(&applyResponse(t, d, api.mutate(newTestReview(t, admissionv1.Create, d))).Spec.Template) {
+		t.Errorf("expected the condition to be met")
+	}
+
+	if _, err := (&config.Condition{Expression: "true"}).Eval(nil, nil); err == nil {
+		t.Errorf("expected a condition that was not compiled to fail")
+	}
+}
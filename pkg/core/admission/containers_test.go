@@ -0,0 +1,62 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateSelectsContainers(t *testing.T) {
+	newConf := func(selector *config.ContainerSelector) *config.Config {
+		return &config.Config{Mixedreslist: []*config.MixedRes{
+			{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Containers: selector},
+		}}
+	}
+	native := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+	}
+	original := newTestDeployment("default", "nginx")
+	original.Spec.Template.Spec.Containers = append(original.Spec.Template.Spec.Containers,
+		corev1.Container{Name: "istio-proxy", Image: "proxyv2", Resources: native},
+		corev1.Container{Name: "app-logger", Image: "fluent-bit", Resources: native},
+	)
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(newConf(&config.ContainerSelector{Exclude: []string{"*-logger"}}))
+	mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+
+	containers := mixed.Spec.Template.Spec.Containers
+	if !hasMixedResources(containers[0]) {
+		t.Errorf("expected the app container to be mixed, got %v", containers[0].Resources)
+	}
+	for _, c := range containers[1:] {
+		if !equality.Semantic.DeepEqual(c.Resources, native) {
+			t.Errorf("expected %s to keep its native resources, got %v", c.Name, c.Resources)
+		}
+	}
+	if _, ok := originalResources(&mixed.Spec.Template)["app-logger"]; ok {
+		t.Errorf("expected excluded containers not to be recorded")
+	}
+
+	// Selecting the logger later mixes it, excluding it again restores it.
+	api.Update(newConf(nil))
+	included := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if c := included.Spec.Template.Spec.Containers[2]; !hasMixedResources(c) {
+		t.Errorf("expected app-logger to be mixed, got %v", c.Resources)
+	}
+	api.Update(newConf(&config.ContainerSelector{Exclude: []string{"*-logger"}}))
+	excluded := applyResponse(t, included, api.mutate(newTestReview(t, admissionv1.Update, included)))
+	if c := excluded.Spec.Template.Spec.Containers[2]; !equality.Semantic.DeepEqual(c.Resources, native) {
+		t.Errorf("expected app-logger to get its native resources back, got %v", c.Resources)
+	}
+	if !equality.Semantic.DeepEqual(excluded.Spec.Template.Spec, mixed.Spec.Template.Spec) {
+		t.Errorf("expected the first mixed pod spec:\n%v\n%v", mixed.Spec.Template.Spec, excluded.Spec.Template.Spec)
+	}
+}
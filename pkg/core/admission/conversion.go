@@ -0,0 +1,84 @@
+package admission
+
+import (
+	"math/big"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// extendedResourceNames are the cmos.mixed counterparts of the native
+// resources.
+var extendedResourceNames = map[corev1.ResourceName]corev1.ResourceName{
+	corev1.ResourceCPU:    corev1.ResourceName(ContainerResourceCpuKey),
+	corev1.ResourceMemory: corev1.ResourceName(ContainerResourceMemoryKey),
+}
+
+// extendedUnit returns the size of one unit of the extended counterpart of a
+// native resource as the fraction num/den of the native unit.
+func extendedUnit(name corev1.ResourceName, conv *config.Conversion) (num, den *big.Int) {
+	if name == corev1.ResourceCPU {
+		return big.NewInt(1), big.NewInt(1000)
+	}
+	return big.NewInt(conv.MemoryUnitBytes()), big.NewInt(1)
+}
+
+// toExtended converts a native cpu or memory quantity to the whole number of
+// units of its cmos.mixed counterpart, rounding as conv says.
+func toExtended(name corev1.ResourceName, q resource.Quantity, conv *config.Conversion) resource.Quantity {
+	num, den := extendedUnit(name, conv)
+	// The quantity is unscaled * 10^-scale native units.
+	dec := q.AsDec()
+	value := new(big.Int).Set(dec.UnscaledBig())
+	divisor := new(big.Int).Set(num)
+	if scale := int64(dec.Scale()); scale < 0 {
+		value.Mul(value, new(big.Int).Exp(big.NewInt(10), big.NewInt(-scale), nil))
+	} else {
+		divisor.Mul(divisor, new(big.Int).Exp(big.NewInt(10), big.NewInt(scale), nil))
+	}
+	value.Mul(value, den)
+
+	quo, rem := new(big.Int).QuoRem(value, divisor, new(big.Int))
+	if rem.Sign() != 0 {
+		switch conv.RoundingMode() {
+		case config.RoundingUp:
+			quo.Add(quo, big.NewInt(1))
+		case config.RoundingNearest:
+			if rem.Lsh(rem, 1).Cmp(divisor) >= 0 {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+	return *resource.NewQuantity(quo.Int64(), resource.DecimalSI)
+}
+
+// fromExtended converts a cmos.mixed cpu or memory value back to the native
+// quantity it stands for.
+func fromExtended(name corev1.ResourceName, q resource.Quantity, conv *config.Conversion) resource.Quantity {
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(q.Value(), resource.DecimalSI)
+	}
+	return *resource.NewQuantity(q.Value()*conv.MemoryUnitBytes(), resource.BinarySI)
+}
+
+// mirroredResources returns the cmos.mixed resources mutateContainerResource
+// gives a container with the given native resources. Extended resources
+// must have equal requests and limits, so the list is used for both. It is
+// based on the native requests, or the limits where a request is missing.
+func mirroredResources(native corev1.ResourceRequirements, conv *config.Conversion) corev1.ResourceList {
+	list := corev1.ResourceList{
+		corev1.ResourceName(ContainerResourcePodCountKey): resource.MustParse("1"),
+	}
+	for _, name := range nativeResourceNames {
+		q, ok := native.Requests[name]
+		if !ok {
+			q, ok = native.Limits[name]
+		}
+		if ok {
+			list[extendedResourceNames[name]] = toExtended(name, q, conv)
+		}
+	}
+	return list
+}
@@ -0,0 +1,93 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestToExtended(t *testing.T) {
+	mi := func(r config.Rounding) *config.Conversion {
+		return &config.Conversion{MemoryUnit: config.MemoryUnitMi, Rounding: r}
+	}
+
+	tests := []struct {
+		name     corev1.ResourceName
+		quantity string
+		conv     *config.Conversion
+		want     int64
+	}{
+		{corev1.ResourceCPU, "500m", nil, 500},
+		{corev1.ResourceCPU, "1", nil, 1000},
+		{corev1.ResourceCPU, "2.5", nil, 2500},
+		{corev1.ResourceCPU, "0.1", nil, 100},
+		{corev1.ResourceCPU, "1k", nil, 1000000},
+		{corev1.ResourceCPU, "1e2", nil, 100000},
+		{corev1.ResourceCPU, "1500u", nil, 2},
+		{corev1.ResourceCPU, "1500u", &config.Conversion{Rounding: config.RoundingDown}, 1},
+		{corev1.ResourceCPU, "1499u", &config.Conversion{Rounding: config.RoundingNearest}, 1},
+		{corev1.ResourceCPU, "1500u", &config.Conversion{Rounding: config.RoundingNearest}, 2},
+		{corev1.ResourceCPU, "100n", nil, 1},
+		{corev1.ResourceMemory, "512Mi", nil, 512 << 20},
+		{corev1.ResourceMemory, "1Gi", nil, 1 << 30},
+		{corev1.ResourceMemory, "1G", nil, 1000000000},
+		{corev1.ResourceMemory, "128974848", nil, 128974848},
+		{corev1.ResourceMemory, "129e6", nil, 129000000},
+		{corev1.ResourceMemory, "1.5Ki", nil, 1536},
+		{corev1.ResourceMemory, "512Mi", mi(""), 512},
+		{corev1.ResourceMemory, "1Gi", mi(""), 1024},
+		{corev1.ResourceMemory, "1500Ki", mi(config.RoundingUp), 2},
+		{corev1.ResourceMemory, "1500Ki", mi(config.RoundingDown), 1},
+		{corev1.ResourceMemory, "1500Ki", mi(config.RoundingNearest), 1},
+		{corev1.ResourceMemory, "1536Ki", mi(config.RoundingNearest), 2},
+		{corev1.ResourceMemory, "100M", mi(config.RoundingUp), 96},
+		{corev1.ResourceMemory, "100M", mi(config.RoundingDown), 95},
+		{corev1.ResourceMemory, "2Gi", &config.Conversion{MemoryUnit: config.MemoryUnitGi}, 2},
+		{corev1.ResourceMemory, "0", mi(""), 0},
+	}
+	for _, tt := range tests {
+		got := toExtended(tt.name, resource.MustParse(tt.quantity), tt.conv)
+		if got.Value() != tt.want || got.MilliValue() != tt.want*1000 {
+			t.Errorf("toExtended(%s, %s, %+v) = %s, want %d", tt.name, tt.quantity, tt.conv, got.String(), tt.want)
+		}
+	}
+
+	// Whole units convert back unchanged.
+	if back := fromExtended(corev1.ResourceCPU, resource.MustParse("500"), nil); back.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("fromExtended(cpu, 500) = %s, want 500m", back.String())
+	}
+	if back := fromExtended(corev1.ResourceMemory, resource.MustParse("512"), mi("")); back.Cmp(resource.MustParse("512Mi")) != 0 {
+		t.Errorf("fromExtended(memory, 512) = %s, want 512Mi", back.String())
+	}
+}
+
+func TestMutateExtendedRequestsEqualLimits(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{
+		Conversion:   &config.Conversion{MemoryUnit: config.MemoryUnitMi},
+		Mixedreslist: []*config.MixedRes{{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10}},
+	})
+
+	// Requests of 250m and 100M, limits of 2 and 1Gi.
+	d := newTestDeployment("default", "nginx")
+	d.Spec.Template.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("250m"),
+		corev1.ResourceMemory: resource.MustParse("100M"),
+	}
+	c := applyResponse(t, d, api.mutate(newTestReview(t, admissionv1.Create, d))).Spec.Template.Spec.Containers[0]
+
+	want := corev1.ResourceList{
+		corev1.ResourceName(ContainerResourceCpuKey):      resource.MustParse("250"),
+		corev1.ResourceName(ContainerResourceMemoryKey):   resource.MustParse("96"),
+		corev1.ResourceName(ContainerResourcePodCountKey): resource.MustParse("1"),
+	}
+	if !equality.Semantic.DeepEqual(c.Resources.Requests, want) || !equality.Semantic.DeepEqual(c.Resources.Limits, want) {
+		t.Errorf("resources = %v, want requests and limits %v", c.Resources, want)
+	}
+}
@@ -0,0 +1,44 @@
+package admission
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// effectiveRules is the body of the rules debug view.
+type effectiveRules struct {
+	Global     config.Settings           `json:"global"`
+	Namespaces []*config.NamespacePolicy `json:"namespaces,omitempty"`
+	Rules      []*config.MixedRes        `json:"rules"`
+}
+
+// serveRules shows the effective rules together with the layer that supplied
+// each value. With the namespace and name query parameters it resolves a
+// single workload the way mutate would, annotations aside.
+func (api *API) serveRules(w http.ResponseWriter, r *http.Request) {
+	logger := log.With(api.logger, "admission", "debug")
+	conf := api.snapshot()
+
+	body := effectiveRules{
+		Global:     conf.Global,
+		Namespaces: conf.Namespaces,
+		Rules:      conf.Mixedreslist,
+	}
+	if namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("name"); namespace != "" || name != "" {
+		body.Rules = nil
+		if rule, required, _ := api.mutationRequired(conf, &metav1.ObjectMeta{Namespace: namespace, Name: name}); required {
+			body.Rules = []*config.MixedRes{rule}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		level.Error(logger).Log("err", err)
+	}
+}
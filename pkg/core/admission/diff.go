@@ -0,0 +1,45 @@
+package admission
+
+import (
+	"reflect"
+	"sort"
+)
+
+// jsonDiff returns the patch that turns the decoded JSON value from into to.
+// Objects are compared key by key, anything else is replaced as a whole.
+func jsonDiff(path string, from, to interface{}) (patch []PatchOperation) {
+	fromMap, ok1 := from.(map[string]interface{})
+	toMap, ok2 := to.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if !reflect.DeepEqual(from, to) {
+			patch = append(patch, PatchOperation{Op: "replace", Path: path, Value: to})
+		}
+		return patch
+	}
+
+	keys := make([]string, 0, len(fromMap)+len(toMap))
+	for key := range fromMap {
+		keys = append(keys, key)
+	}
+	for key := range toMap {
+		if _, ok := fromMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		p := path + "/" + escapeJSONPointer(key)
+		f, inFrom := fromMap[key]
+		t, inTo := toMap[key]
+		switch {
+		case !inTo:
+			patch = append(patch, PatchOperation{Op: "remove", Path: p})
+		case !inFrom:
+			patch = append(patch, PatchOperation{Op: "add", Path: p, Value: t})
+		default:
+			patch = append(patch, jsonDiff(p, f, t)...)
+		}
+	}
+	return patch
+}
@@ -0,0 +1,72 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// eligibilityFailures lists every eligibility check the deployment fails.
+// Resource requests are compared in native units, also for a template an
+// earlier mutation already made mixed.
+func eligibilityFailures(e *config.Eligibility, deployment *appsv1.Deployment) (failures []string) {
+	if e.MinReplicas != nil {
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		if replicas < *e.MinReplicas {
+			failures = append(failures, fmt.Sprintf("%d replicas are fewer than %d", replicas, *e.MinReplicas))
+		}
+	}
+	spec := &deployment.Spec.Template.Spec
+	if e.NoPersistentVolumeClaims {
+		for _, v := range spec.Volumes {
+			if v.PersistentVolumeClaim != nil || v.Ephemeral != nil {
+				failures = append(failures, fmt.Sprintf("volume %s is backed by a persistent volume claim", v.Name))
+			}
+		}
+	}
+	if e.MaxPodCPU != nil || e.MaxPodMemory != nil {
+		original := originalResources(&deployment.Spec.Template)
+		requests := corev1.ResourceList{}
+		for _, c := range spec.Containers {
+			for name, q := range nativeResources(c, original).Requests {
+				sum := requests[name]
+				sum.Add(q)
+				requests[name] = sum
+			}
+		}
+		for _, ceiling := range []struct {
+			name corev1.ResourceName
+			max  *resource.Quantity
+		}{{corev1.ResourceCPU, e.MaxPodCPU}, {corev1.ResourceMemory, e.MaxPodMemory}} {
+			if ceiling.max == nil {
+				continue
+			}
+			if q := requests[ceiling.name]; q.Cmp(*ceiling.max) > 0 {
+				failures = append(failures, fmt.Sprintf("%s requests of the pod %s exceed %s", ceiling.name, q.String(), ceiling.max.String()))
+			}
+		}
+	}
+	return failures
+}
+
+// eligibilityReason returns why a deployment may not be made mixed under its
+// rule, or an empty reason if it may.
+func eligibilityReason(rule *config.MixedRes, deployment *appsv1.Deployment) string {
+	if rule.Eligibility == nil {
+		return ""
+	}
+	failures := eligibilityFailures(rule.Eligibility, deployment)
+	if len(failures) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("workload %s/%s is not eligible for mixed mode: %s",
+		deployment.Namespace, deployment.Name, strings.Join(failures, "; "))
+}
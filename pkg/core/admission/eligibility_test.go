@@ -0,0 +1,85 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateEligibility(t *testing.T) {
+	minReplicas := int32(2)
+	maxCPU, maxMemory := resource.MustParse("1500m"), resource.MustParse("1Gi")
+	eligibility := &config.Eligibility{MinReplicas: &minReplicas, NoPersistentVolumeClaims: true, MaxPodCPU: &maxCPU, MaxPodMemory: &maxMemory}
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Eligibility: eligibility},
+	}})
+
+	eligible := newTestDeployment("default", "nginx")
+	eligible.Spec.Replicas = &minReplicas
+	mixed := applyResponse(t, eligible, api.mutate(newTestReview(t, admissionv1.Create, eligible)))
+	if !wasMixed(&mixed.Spec.Template) {
+		t.Fatalf("expected an eligible workload to be made mixed")
+	}
+
+	ineligible := mixed.DeepCopy()
+	ineligible.Spec.Replicas = nil
+	spec := &ineligible.Spec.Template.Spec
+	spec.Volumes = []corev1.Volume{
+		{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+		{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name: "worker", Image: "worker",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+	})
+	resp := api.mutate(newTestReview(t, admissionv1.Update, ineligible))
+	if !resp.Allowed {
+		t.Fatalf("expected an ineligible workload to be allowed, got %v", resp.Result)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected one warning, got %v", resp.Warnings)
+	}
+	for _, failure := range []string{
+		"1 replicas are fewer than 2",
+		"volume data is backed by a persistent volume claim",
+		"cpu requests of the pod 2 exceed 1500m",
+	} {
+		if !strings.Contains(resp.Warnings[0], failure) {
+			t.Errorf("expected %q in %q", failure, resp.Warnings[0])
+		}
+	}
+	if strings.Contains(resp.Warnings[0], "cache") || strings.Contains(resp.Warnings[0], "memory") {
+		t.Errorf("unexpected failure in %q", resp.Warnings[0])
+	}
+	if unmixed := applyResponse(t, ineligible, resp); wasMixed(&unmixed.Spec.Template) {
+		t.Errorf("expected the ineligible workload to leave mixed mode, got %v", unmixed.Spec.Template)
+	}
+}
+
+func TestMutateFallbackSkipsPodOverlay(t *testing.T) {
+	minReplicas := int32(2)
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: true, Priority: 10,
+		Eligibility: &config.Eligibility{MinReplicas: &minReplicas},
+		PodOverlay:  []byte(`{"schedulerName": "mixed-scheduler", "runtimeClassName": "kata"}`),
+	}}})
+
+	d := newTestDeployment("default", "nginx")
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed || len(resp.Warnings) != 1 {
+		t.Fatalf("expected a fallback with a warning, got %v %v", resp.Result, resp.Warnings)
+	}
+	spec := applyResponse(t, d, resp).Spec.Template.Spec
+	if spec.SchedulerName != "" || spec.RuntimeClassName != nil {
+		t.Errorf("expected no overlay on a fallback, got scheduler %q, runtime class %v", spec.SchedulerName, spec.RuntimeClassName)
+	}
+}
@@ -0,0 +1,84 @@
+package admission
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Reasons of the events recorded against admitted workloads.
+const (
+	// EventReasonMixed is recorded when a workload is made mixed.
+	EventReasonMixed = "MixedMutation"
+	// EventReasonUnmixed is recorded when a workload is taken back out of
+	// mixed mode.
+	EventReasonUnmixed = "MixedRemoved"
+	// EventReasonSkipped is recorded when a rule matches a workload but a
+	// filter or condition of the rule leaves it alone, or when the mixed
+	// annotations of the workload or its namespace are invalid and ignored.
+	EventReasonSkipped = "MixedSkipped"
+	// EventReasonFallback is recorded when a workload is admitted as
+	// non-mixed although its rule asks for mixed mode.
+	EventReasonFallback = "MixedFallback"
+	// EventReasonUnschedulable is recorded when a workload is made mixed
+	// although no node could schedule it.
+	EventReasonUnschedulable = "MixedUnschedulable"
+	// EventReasonDenied is recorded when a workload is rejected.
+	EventReasonDenied = "MixedDenied"
+	// EventReasonAudit is recorded instead of all others when the rule of a
+	// workload is in audit mode.
+	EventReasonAudit = "MixedAudit"
+)
+
+// EventRecorder records Kubernetes events. Implementations must not block.
+type EventRecorder interface {
+	Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{})
+}
+
+// eventFunc records an event about the workload under admission.
+type eventFunc func(eventtype, reason, messageFmt string, args ...interface{})
+
+func discardEvent(string, string, string, ...interface{}) {}
+
+// SetEventRecorder makes the API record events about the workloads it
+// mutates or skips. It must be called before requests are served. A nil
+// recorder disables events.
+func (api *API) SetEventRecorder(recorder EventRecorder) {
+	api.events = recorder
+}
+
+// event records an event against the workload of req. Dry-run requests are
+// skipped, since nothing they admit is persisted.
+func (api *API) event(req *admissionv1.AdmissionRequest, deployment *appsv1.Deployment, eventtype, reason, messageFmt string, args ...interface{}) {
+	if api.events == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+	ref := workloadReference(req, deployment)
+	if ref.Name == "" {
+		// A generated name is only known once the object is stored.
+		return
+	}
+	api.events.Eventf(ref, eventtype, reason, messageFmt, args...)
+}
+
+// workloadReference returns the reference to the workload of req that events
+// are recorded against.
+func workloadReference(req *admissionv1.AdmissionRequest, deployment *appsv1.Deployment) *corev1.ObjectReference {
+	ref := &corev1.ObjectReference{
+		Kind:            req.Kind.Kind,
+		APIVersion:      schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String(),
+		Namespace:       deployment.Namespace,
+		Name:            deployment.Name,
+		UID:             deployment.UID,
+		ResourceVersion: deployment.ResourceVersion,
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = req.Namespace
+	}
+	if ref.Name == "" {
+		ref.Name = req.Name
+	}
+	return ref
+}
@@ -0,0 +1,56 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/tools/record"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.SetEventRecorder(recorder)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Operations: []string{config.OperationCreate}},
+	}})
+
+	next := func() string {
+		select {
+		case e := <-recorder.Events:
+			return e
+		default:
+			return ""
+		}
+	}
+
+	d := newTestDeployment("default", "nginx")
+	api.mutate(newTestReview(t, admissionv1.Create, d))
+	if e := next(); !strings.HasPrefix(e, "Normal "+EventReasonMixed+" ") {
+		t.Errorf("expected a mixed event, got %q", e)
+	}
+
+	api.mutate(newTestReview(t, admissionv1.Update, d))
+	if e := next(); !strings.HasPrefix(e, "Normal "+EventReasonSkipped+" ") {
+		t.Errorf("expected a skipped event, got %q", e)
+	}
+
+	dryRun := newTestReview(t, admissionv1.Create, d)
+	dryRun.Request.DryRun = new(bool)
+	*dryRun.Request.DryRun = true
+	if resp := api.mutate(dryRun); !resp.Allowed || len(resp.Patch) == 0 {
+		t.Errorf("expected a dry run to be mutated, got %v", resp.Result)
+	}
+	if e := next(); e != "" {
+		t.Errorf("expected no event for a dry run, got %q", e)
+	}
+
+	api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment("default", "other")))
+	if e := next(); e != "" {
+		t.Errorf("expected no event for a workload without a rule, got %q", e)
+	}
+}
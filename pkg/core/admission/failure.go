@@ -0,0 +1,76 @@
+package admission
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// fail returns the response to a request that failed with err, which is of
+// the given class: the object is admitted unchanged or the request is
+// denied with code, as the failure policy of conf says. Objects of a kind the
+// webhook does not handle are allowed without a warning, since every such
+// object the webhook configuration routes here would carry it.
+func (api *API) fail(conf *config.Config, class config.FailureClass, code int32, err error) *admissionv1.AdmissionResponse {
+	logger := log.With(api.logger, "admission", "failure")
+	action := conf.FailurePolicy.Action(class)
+	api.failures.WithLabelValues(string(class), string(action)).Inc()
+
+	if action == config.FailureAllow && class == config.FailureUnsupportedKind {
+		level.Info(logger).Log("msg", "unsupported kind allowed unchanged", "err", err)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	level.Error(logger).Log("msg", "admission failed", "class", class, "action", action, "err", err)
+
+	if action == config.FailureAllow {
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{fmt.Sprintf("%s, allowed unchanged", err)},
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Code:    code,
+			Message: err.Error(),
+		},
+	}
+}
+
+// guard returns admit with the panic and timeout failure policies applied.
+// The configuration is read once, and admit is handed the same snapshot, so
+// that a reload during the request cannot mix two configurations. A request
+// that times out keeps running in the background, but its response is
+// discarded.
+func (api *API) guard(admit configAdmitFunc) admitFunc {
+	return func(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		conf := api.snapshot()
+		done := make(chan *admissionv1.AdmissionResponse, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- api.fail(conf, config.FailurePanic, http.StatusInternalServerError, fmt.Errorf("panic: %v", r))
+				}
+			}()
+			done <- admit(conf, ar)
+		}()
+
+		deadline := conf.FailurePolicy.EffectiveDeadline()
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		select {
+		case resp := <-done:
+			return resp
+		case <-timer.C:
+			return api.fail(conf, config.FailureTimeout, http.StatusGatewayTimeout, fmt.Errorf("admission did not finish within %s", deadline))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package admission
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateFailurePolicy(t *testing.T) {
+	statefulSet := admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+		Namespace: "default",
+		Name:      "db",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{}`)},
+	}}
+	broken := newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx"))
+	broken.Request.Object.Raw = []byte(`{"spec": []}`)
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{})
+	if resp := api.mutate(statefulSet); !resp.Allowed || len(resp.Patch) != 0 || len(resp.Warnings) != 0 {
+		t.Errorf("expected an unsupported kind to be allowed unchanged without a warning, got %v", resp)
+	}
+	if resp := api.mutate(broken); !resp.Allowed || len(resp.Patch) != 0 || len(resp.Warnings) != 1 {
+		t.Errorf("expected the object to be allowed unchanged with a warning by default, got %v", resp)
+	}
+
+	api.Update(&config.Config{FailurePolicy: &config.FailurePolicy{UnsupportedKind: config.FailureDeny, Decode: config.FailureDeny}})
+	for _, review := range []admissionv1.AdmissionReview{statefulSet, broken} {
+		if resp := api.mutate(review); resp.Allowed || resp.Result.Code != http.StatusBadRequest {
+			t.Errorf("expected the request to be denied, got %v", resp)
+		}
+	}
+
+	for class, want := range map[config.FailureClass]map[config.FailureAction]float64{
+		config.FailureUnsupportedKind: {config.FailureAllow: 1, config.FailureDeny: 1},
+		config.FailureDecode:          {config.FailureAllow: 1, config.FailureDeny: 1},
+	} {
+		for action, n := range want {
+			if got := testutil.ToFloat64(api.failures.WithLabelValues(string(class), string(action))); got != n {
+				t.Errorf("failures{class=%s, action=%s} = %v, want %v", class, action, got, n)
+			}
+		}
+	}
+}
+
+func TestGuardFailurePolicy(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{FailurePolicy: &config.FailurePolicy{Panic: config.FailureDeny, Deadline: model.Duration(50 * time.Millisecond)}})
+	review := newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx"))
+
+	panics := api.guard(func(*config.Config, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse { panic("boom") })
+	if resp := panics(review); resp.Allowed || resp.Result.Code != http.StatusInternalServerError {
+		t.Errorf("expected a panic to be denied, got %v", resp)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	hangs := api.guard(func(*config.Config, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		<-release
+		return &admissionv1.AdmissionResponse{}
+	})
+	if resp := hangs(review); !resp.Allowed || len(resp.Warnings) != 1 {
+		t.Errorf("expected a timed out request to be allowed unchanged, got %v", resp)
+	}
+
+	conf := api.snapshot()
+	reloads := api.guard(func(got *config.Config, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		api.Update(&config.Config{})
+		if got != conf {
+			t.Errorf("expected the request to be handled with the snapshot the guard took")
+		}
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	})
+	reloads(review)
+	api.Update(conf)
+
+	if resp := api.guard(api.mutateWith)(review); !resp.Allowed || len(resp.Warnings) != 0 {
+		t.Errorf("expected a regular request to pass through, got %v", resp)
+	}
+	if got := testutil.ToFloat64(api.failures.WithLabelValues(string(config.FailurePanic), string(config.FailureDeny))); got != 1 {
+		t.Errorf("panic failures = %v", got)
+	}
+	if got := testutil.ToFloat64(api.failures.WithLabelValues(string(config.FailureTimeout), string(config.FailureAllow))); got != 1 {
+		t.Errorf("timeout failures = %v", got)
+	}
+}
+
+type failingMutator struct{}
+
+func (failingMutator) Name() string { return "test-fail" }
+
+func (failingMutator) Applies(*admissionv1.AdmissionRequest, *config.MixedRes) bool { return true }
+
+func (failingMutator) Mutate(*Object) ([]PatchOperation, error) {
+	return nil, errors.New("boom")
+}
+
+func TestMutateMutatorFailurePolicy(t *testing.T) {
+	if _, ok := lookupMutator("test-fail"); !ok {
+		RegisterMutator(failingMutator{})
+	}
+	rule := &config.MixedRes{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Mutators: []string{"labels", "test-fail"}}
+	review := newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx"))
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	if err := api.Update(&config.Config{Mixedreslist: []*config.MixedRes{rule}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := api.mutate(review); !resp.Allowed || len(resp.Patch) != 0 || len(resp.Warnings) != 1 {
+		t.Errorf("expected the object to be allowed unchanged by default, got %v", resp)
+	}
+
+	if err := api.Update(&config.Config{FailurePolicy: &config.FailurePolicy{Mutator: config.FailureDeny}, Mixedreslist: []*config.MixedRes{rule}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := api.mutate(review); resp.Allowed || resp.Result.Code != http.StatusInternalServerError {
+		t.Errorf("expected the request to be denied, got %v", resp)
+	}
+	for action, n := range map[config.FailureAction]float64{config.FailureAllow: 1, config.FailureDeny: 1} {
+		if got := testutil.ToFloat64(api.failures.WithLabelValues(string(config.FailureMutator), string(action))); got != n {
+			t.Errorf("failures{class=mutator, action=%s} = %v, want %v", action, got, n)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package admission
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// mixedPodRequests sums the mixed extended resources the containers of a pod
+// template request.
+func mixedPodRequests(template *corev1.PodTemplateSpec) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, c := range template.Spec.Containers {
+		for _, name := range mixedResourceNames {
+			if q, ok := c.Resources.Requests[name]; ok {
+				sum := requests[name]
+				sum.Add(q)
+				requests[name] = sum
+			}
+		}
+	}
+	return requests
+}
+
+// fits reports whether allocatable covers every request.
+func fits(allocatable, requests corev1.ResourceList) bool {
+	for name, q := range requests {
+		if a, ok := allocatable[name]; !ok || a.Cmp(q) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func formatResources(list corev1.ResourceList) string {
+	items := make([]string, 0, len(list))
+	for name, q := range list {
+		items = append(items, fmt.Sprintf("%s=%s", name, q.String()))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// feasibilityReason returns why no node could ever schedule a pod of the
+// mutated deployment, or an empty reason if some node could. A node could if
+// it is schedulable, matches the node selector of the pod template and has
+// at least the mixed extended resources a pod requests allocatable. Taints,
+// affinities and the pods already running are not taken into account. The
+// check is skipped without the node informer.
+func (api *API) feasibilityReason(deployment *appsv1.Deployment) string {
+	logger := log.With(api.logger, "admission", "feasibility")
+	if api.cluster == nil || api.cluster.Nodes == nil {
+		return ""
+	}
+	selector := labels.SelectorFromSet(deployment.Spec.Template.Spec.NodeSelector)
+	nodes, err := api.cluster.Nodes.List(selector)
+	if err != nil {
+		level.Error(logger).Log("msg", "listing nodes failed", "err", err)
+		return ""
+	}
+	requests := mixedPodRequests(&deployment.Spec.Template)
+	schedulable := 0
+	for _, node := range nodes {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		schedulable++
+		if fits(node.Status.Allocatable, requests) {
+			return ""
+		}
+	}
+	if schedulable == 0 {
+		return fmt.Sprintf("no schedulable node matches the node selector %q of mixed workload %s/%s",
+			selector.String(), deployment.Namespace, deployment.Name)
+	}
+	return fmt.Sprintf("none of the %d schedulable nodes matching the node selector %q has %s allocatable for a pod of mixed workload %s/%s",
+		schedulable, selector.String(), formatResources(requests), deployment.Namespace, deployment.Name)
+}
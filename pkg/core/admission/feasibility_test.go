@@ -0,0 +1,65 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func newTestNode(name string, labels map[string]string, cpu string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceName(ContainerResourceCpuKey):      resource.MustParse(cpu),
+			corev1.ResourceName(ContainerResourceMemoryKey):   resource.MustParse("64Gi"),
+			corev1.ResourceName(ContainerResourcePodCountKey): resource.MustParse("110"),
+		}},
+	}
+}
+
+func TestMutateFeasibility(t *testing.T) {
+	mixedNode := map[string]string{PodNodeSelectorKey: "true"}
+	for _, tt := range []struct {
+		name   string
+		nodes  []runtime.Object
+		action config.FeasibilityAction
+		// mixed and warning are the expected outcome.
+		mixed   bool
+		warning string
+	}{
+		{"fits", []runtime.Object{newTestNode("a", mixedNode, "500"), newTestNode("b", mixedNode, "4000")}, "", true, ""},
+		{"no nodes", []runtime.Object{newTestNode("a", nil, "4000")}, "", true, "no schedulable node matches the node selector"},
+		{"too small", []runtime.Object{newTestNode("a", mixedNode, "500")}, "", true, "none of the 1 schedulable nodes"},
+		{"fallback", []runtime.Object{newTestNode("a", mixedNode, "500")}, config.FeasibilityFallback, false, "admitted as non-mixed"},
+		{"ignore", nil, config.FeasibilityIgnore, true, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(log.NewNopLogger(), newTestCluster(t, true, tt.nodes...))
+			api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+				{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Feasibility: &config.Feasibility{Action: tt.action}},
+			}})
+			d := newTestDeployment("default", "nginx")
+			resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+			if !resp.Allowed {
+				t.Fatalf("expected the workload to be allowed, got %v", resp.Result)
+			}
+			if mixed := wasMixed(&applyResponse(t, d, resp).Spec.Template); mixed != tt.mixed {
+				t.Errorf("mixed = %v, want %v", mixed, tt.mixed)
+			}
+			switch {
+			case tt.warning == "" && len(resp.Warnings) > 0:
+				t.Errorf("unexpected warnings %v", resp.Warnings)
+			case tt.warning != "" && (len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], tt.warning)):
+				t.Errorf("expected a warning containing %q, got %v", tt.warning, resp.Warnings)
+			}
+		})
+	}
+}
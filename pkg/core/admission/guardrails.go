@@ -0,0 +1,60 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// guardrailViolations lists every guardrail the pod template of the mutated
+// deployment violates.
+func guardrailViolations(g *config.Guardrails, deployment *appsv1.Deployment) (violations []string) {
+	spec := &deployment.Spec.Template.Spec
+	if g.DenyHostNetwork && spec.HostNetwork {
+		violations = append(violations, "hostNetwork is not allowed")
+	}
+	if g.DenyPrivileged {
+		for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+			if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+				violations = append(violations, fmt.Sprintf("container %s is privileged", c.Name))
+			}
+		}
+	}
+	if g.DenyHostPath {
+		for _, v := range spec.Volumes {
+			if v.HostPath != nil {
+				violations = append(violations, fmt.Sprintf("volume %s is a hostPath volume", v.Name))
+			}
+		}
+	}
+	if g.MaxPodCPU != nil {
+		var cpu resource.Quantity
+		for _, c := range spec.Containers {
+			cpu.Add(fromExtended(corev1.ResourceCPU, c.Resources.Requests[corev1.ResourceName(ContainerResourceCpuKey)], nil))
+		}
+		if cpu.Cmp(*g.MaxPodCPU) > 0 {
+			violations = append(violations, fmt.Sprintf("%s requests of the pod %s exceed %s", ContainerResourceCpuKey, cpu.String(), g.MaxPodCPU.String()))
+		}
+	}
+	return violations
+}
+
+// guardrailsReason returns why a mixed deployment, with all mutations
+// applied, is rejected by the guardrails of its rule, or an empty reason if
+// it is not.
+func guardrailsReason(rule *config.MixedRes, deployment *appsv1.Deployment) string {
+	if rule.Guardrails == nil {
+		return ""
+	}
+	violations := guardrailViolations(rule.Guardrails, deployment)
+	if len(violations) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("mixed workload %s/%s violates the co-location guardrails: %s",
+		deployment.Namespace, deployment.Name, strings.Join(violations, "; "))
+}
@@ -0,0 +1,65 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateGuardrails(t *testing.T) {
+	maxCPU := resource.MustParse("1500m")
+	guardrails := &config.Guardrails{DenyHostNetwork: true, DenyPrivileged: true, DenyHostPath: true, MaxPodCPU: &maxCPU}
+	privileged := true
+
+	unsafe := newTestDeployment("default", "nginx")
+	spec := &unsafe.Spec.Template.Spec
+	spec.HostNetwork = true
+	spec.InitContainers = []corev1.Container{{Name: "setup", Image: "busybox", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}
+	spec.Volumes = []corev1.Volume{
+		{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}},
+		{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name: "worker", Image: "worker",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+	})
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, Guardrails: guardrails},
+	}})
+	resp := api.mutate(newTestReview(t, admissionv1.Create, unsafe))
+	if resp.Allowed {
+		t.Fatal("expected the unsafe workload to be denied")
+	}
+	for _, violation := range []string{
+		"hostNetwork is not allowed",
+		"container setup is privileged",
+		"volume logs is a hostPath volume",
+		"cmos.mixed/cpu requests of the pod 2 exceed 1500m",
+	} {
+		if !strings.Contains(resp.Result.Message, violation) {
+			t.Errorf("expected %q in %q", violation, resp.Result.Message)
+		}
+	}
+	if strings.Contains(resp.Result.Message, "cache") {
+		t.Errorf("unexpected violation in %q", resp.Result.Message)
+	}
+
+	if resp := api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment("default", "nginx"))); !resp.Allowed {
+		t.Errorf("expected a compliant workload to be allowed, got %v", resp.Result)
+	}
+
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: false, Priority: 10, Guardrails: guardrails},
+	}})
+	if resp := api.mutate(newTestReview(t, admissionv1.Create, unsafe)); !resp.Allowed {
+		t.Errorf("expected guardrails to apply to mixed workloads only, got %v", resp.Result)
+	}
+}
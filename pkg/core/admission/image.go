@@ -0,0 +1,157 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodAnnotationOriginalImagesKey records the images, keyed by container
+// name, that a mixed mutation rewrote to a mirror.
+const PodAnnotationOriginalImagesKey string = "hc/original-images"
+
+// normalizeImage returns the image reference with its registry spelled out,
+// so that "nginx:1.21" becomes "docker.io/library/nginx:1.21".
+func normalizeImage(image string) string {
+	i := strings.IndexRune(image, '/')
+	if i == -1 || (!strings.ContainsAny(image[:i], ".:") && image[:i] != "localhost") {
+		image = "docker.io/" + image
+		i = len("docker.io")
+	}
+	if image[:i] == "docker.io" && !strings.ContainsRune(image[i+1:], '/') {
+		image = "docker.io/library/" + image[i+1:]
+	}
+	return image
+}
+
+// rewriteImage replaces the longest prefix of rewrites that the normalized
+// image starts with. A prefix must end at a path component, tag or digest.
+// The tag or digest of the image is kept as it is.
+func rewriteImage(image string, rewrites map[string]string) (string, bool) {
+	normalized := normalizeImage(image)
+	prefixes := make([]string, 0, len(rewrites))
+	for prefix := range rewrites {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	for _, prefix := range prefixes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if !strings.HasPrefix(normalized, trimmed) {
+			continue
+		}
+		rest := normalized[len(trimmed):]
+		if rest != "" && rest[0] != '/' && rest[0] != '@' && (rest[0] != ':' || strings.ContainsRune(rest, '/')) {
+			continue
+		}
+		return strings.TrimSuffix(rewrites[prefix], "/") + rest, true
+	}
+	return image, false
+}
+
+// rewrittenImage is the record of one rewritten container image.
+type rewrittenImage struct {
+	Original string `json:"original"`
+	Mirror   string `json:"mirror"`
+}
+
+// originalImages returns the images recorded on a template by an earlier
+// rewrite, keyed by container name.
+func originalImages(template *corev1.PodTemplateSpec) map[string]rewrittenImage {
+	original := map[string]rewrittenImage{}
+	if value, ok := template.Annotations[PodAnnotationOriginalImagesKey]; ok {
+		// A broken annotation is treated as absent.
+		_ = json.Unmarshal([]byte(value), &original)
+	}
+	return original
+}
+
+// containerPaths are the JSON Patch paths of the container lists of a pod
+// template.
+var containerPaths = []string{"/spec/template/spec/initContainers", "/spec/template/spec/containers"}
+
+func templateContainers(template *corev1.PodTemplateSpec, path string) []corev1.Container {
+	if path == containerPaths[0] {
+		return template.Spec.InitContainers
+	}
+	return template.Spec.Containers
+}
+
+// mutateImages rewrites the container and init container images of the
+// template to their mirrors and records the original images. A container
+// that still runs the recorded mirror keeps its recorded original, so that
+// updates of a rewritten workload are stable, and gets it back if no rewrite
+// covers it any more.
+func mutateImages(deployment *appsv1.Deployment, rewrites map[string]string) (patch []PatchOperation) {
+	template := &deployment.Spec.Template
+	recorded := originalImages(template)
+	record := map[string]rewrittenImage{}
+
+	for _, path := range containerPaths {
+		for index, c := range templateContainers(template, path) {
+			original := c.Image
+			if prev, ok := recorded[c.Name]; ok && prev.Mirror == c.Image {
+				original = prev.Original
+			}
+			mirror, ok := rewriteImage(original, rewrites)
+			if !ok {
+				if original != c.Image {
+					patch = append(patch, PatchOperation{
+						Op:    "replace",
+						Path:  fmt.Sprintf("%s/%d/image", path, index),
+						Value: original,
+					})
+				}
+				continue
+			}
+			record[c.Name] = rewrittenImage{Original: original, Mirror: mirror}
+			if mirror != c.Image {
+				patch = append(patch, PatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("%s/%d/image", path, index),
+					Value: mirror,
+				})
+			}
+		}
+	}
+	if len(record) == 0 {
+		if _, ok := template.Annotations[PodAnnotationOriginalImagesKey]; ok {
+			patch = append(patch, PatchOperation{
+				Op:   "remove",
+				Path: "/spec/template/metadata/annotations/" + escapeJSONPointer(PodAnnotationOriginalImagesKey),
+			})
+		}
+		return patch
+	}
+	value, _ := json.Marshal(record)
+	return append(patch, mutatePodAnnotations(template.Annotations, map[string]string{
+		PodAnnotationOriginalImagesKey: string(value),
+	})...)
+}
+
+// restoreImages returns the patch that puts back the recorded original
+// images of the containers that still run the recorded mirror.
+func restoreImages(template *corev1.PodTemplateSpec) (patch []PatchOperation) {
+	recorded := originalImages(template)
+	for _, path := range containerPaths {
+		for index, c := range templateContainers(template, path) {
+			if prev, ok := recorded[c.Name]; ok && prev.Mirror == c.Image && prev.Original != c.Image {
+				patch = append(patch, PatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("%s/%d/image", path, index),
+					Value: prev.Original,
+				})
+			}
+		}
+	}
+	return patch
+}
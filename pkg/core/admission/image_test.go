@@ -0,0 +1,123 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestRewriteImage(t *testing.T) {
+	rewrites := map[string]string{
+		"docker.io":          "mirror.local/dockerhub",
+		"docker.io/library/": "mirror.local/library/",
+		"quay.io/org":        "mirror.local/quay-org",
+		"registry:5000/app":  "mirror.local/app",
+	}
+	digest := "@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		image string
+		want  string
+		ok    bool
+	}{
+		{"nginx", "mirror.local/library/nginx", true},
+		{"nginx:1.21", "mirror.local/library/nginx:1.21", true},
+		{"nginx" + digest, "mirror.local/library/nginx" + digest, true},
+		{"docker.io/nginx:1.21", "mirror.local/library/nginx:1.21", true},
+		{"bitnami/redis:7", "mirror.local/dockerhub/bitnami/redis:7", true},
+		{"quay.io/org/app:v1" + digest, "mirror.local/quay-org/app:v1" + digest, true},
+		{"quay.io/organization/app:v1", "quay.io/organization/app:v1", false},
+		{"quay.io/other/app", "quay.io/other/app", false},
+		{"registry:5000/app:v2", "mirror.local/app:v2", true},
+		{"localhost/app:v1", "localhost/app:v1", false},
+		{"mirror.local/library/nginx:1.21", "mirror.local/library/nginx:1.21", false},
+	}
+	for _, tt := range tests {
+		got, ok := rewriteImage(tt.image, rewrites)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("rewriteImage(%q) = %q, %v, want %q, %v", tt.image, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMutateRewritesImages(t *testing.T) {
+	rewrites := map[string]string{"docker.io": "mirror.local/dockerhub"}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, ImageRewrites: rewrites},
+	}})
+
+	original := newTestDeployment("default", "nginx")
+	original.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+	original.Spec.Template.Spec.Containers = append(original.Spec.Template.Spec.Containers,
+		corev1.Container{Name: "local", Image: "registry.local/tools/log:1"})
+	mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+
+	spec := mixed.Spec.Template.Spec
+	if spec.InitContainers[0].Image != "mirror.local/dockerhub/library/busybox" {
+		t.Errorf("init container image = %q", spec.InitContainers[0].Image)
+	}
+	if spec.Containers[0].Image != "mirror.local/dockerhub/library/nginx:1.21" {
+		t.Errorf("container image = %q", spec.Containers[0].Image)
+	}
+	if spec.Containers[1].Image != "registry.local/tools/log:1" {
+		t.Errorf("unmatched image must be kept, got %q", spec.Containers[1].Image)
+	}
+	recorded := originalImages(&mixed.Spec.Template)
+	if len(recorded) != 2 || recorded["nginx"].Original != "nginx:1.21" || recorded["init"].Original != "busybox" {
+		t.Errorf("recorded images = %v", recorded)
+	}
+
+	again := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if !equality.Semantic.DeepEqual(again.Spec.Template, mixed.Spec.Template) {
+		t.Errorf("second mutation changed the template:\n%v\n%v", mixed.Spec.Template, again.Spec.Template)
+	}
+
+	// An image changed by the user is rewritten and recorded again.
+	changed := mixed.DeepCopy()
+	changed.Spec.Template.Spec.Containers[0].Image = "nginx:1.22"
+	changed = applyResponse(t, changed, api.mutate(newTestReview(t, admissionv1.Update, changed)))
+	if changed.Spec.Template.Spec.Containers[0].Image != "mirror.local/dockerhub/library/nginx:1.22" {
+		t.Errorf("changed image = %q", changed.Spec.Template.Spec.Containers[0].Image)
+	}
+	if o := originalImages(&changed.Spec.Template)["nginx"].Original; o != "nginx:1.22" {
+		t.Errorf("recorded original = %q", o)
+	}
+
+	// Rewrites that no longer cover an image give it back, also while the
+	// workload stays mixed.
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, ImageRewrites: map[string]string{"docker.io/library/busybox": "mirror.local/busybox"}},
+	}})
+	narrowed := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if spec := narrowed.Spec.Template.Spec; spec.Containers[0].Image != "nginx:1.21" || spec.InitContainers[0].Image != "mirror.local/busybox" {
+		t.Errorf("images = %q, %q", spec.Containers[0].Image, spec.InitContainers[0].Image)
+	}
+	if recorded := originalImages(&narrowed.Spec.Template); len(recorded) != 1 || recorded["init"].Original != "busybox" {
+		t.Errorf("recorded images = %v", recorded)
+	}
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10},
+	}})
+	dropped := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if spec := dropped.Spec.Template.Spec; spec.Containers[0].Image != "nginx:1.21" || spec.InitContainers[0].Image != "busybox" {
+		t.Errorf("images = %q, %q", spec.Containers[0].Image, spec.InitContainers[0].Image)
+	}
+	if _, ok := dropped.Spec.Template.Annotations[PodAnnotationOriginalImagesKey]; ok {
+		t.Errorf("expected the original images annotation to be removed")
+	}
+
+	api.Update(&config.Config{})
+	restored := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if !equality.Semantic.DeepEqual(restored.Spec.Template.Spec, original.Spec.Template.Spec) {
+		t.Errorf("expected original pod spec to be restored:\n%v\n%v", original.Spec.Template.Spec, restored.Spec.Template.Spec)
+	}
+	if _, ok := restored.Spec.Template.Annotations[PodAnnotationOriginalImagesKey]; ok {
+		t.Errorf("expected the original images annotation to be removed")
+	}
+}
@@ -0,0 +1,106 @@
+package admission
+
+import (
+	"sort"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// Sources of a kill switch.
+const (
+	KillSwitchRuntime = "runtime"
+	KillSwitchConfig  = "config"
+)
+
+// KillSwitchStatus is the state of both kill switches.
+type KillSwitchStatus struct {
+	// Runtime is the kill switch set through the lifecycle API.
+	Runtime config.KillSwitch `json:"runtime"`
+	// Config is the kill switch of the configuration file.
+	Config config.KillSwitch `json:"config"`
+}
+
+// Engaged reports whether either kill switch covers any namespace.
+func (s KillSwitchStatus) Engaged() bool {
+	return s.Runtime.All || len(s.Runtime.Namespaces) > 0 || s.Config.All || len(s.Config.Namespaces) > 0
+}
+
+// runtimeKillSwitch returns the kill switch set through the lifecycle API.
+func (api *API) runtimeKillSwitch() *config.KillSwitch {
+	if k, ok := api.killSwitch.Load().(*config.KillSwitch); ok {
+		return k
+	}
+	return &config.KillSwitch{}
+}
+
+// setKillSwitch replaces the runtime kill switch by a copy that update
+// changed. The runtime kill switch is kept apart from the configuration, so
+// reloads do not release it.
+func (api *API) setKillSwitch(update func(k *config.KillSwitch)) {
+	api.killSwitchMtx.Lock()
+	defer api.killSwitchMtx.Unlock()
+
+	current := api.runtimeKillSwitch()
+	k := &config.KillSwitch{All: current.All, Namespaces: append([]string(nil), current.Namespaces...)}
+	update(k)
+	sort.Strings(k.Namespaces)
+	api.killSwitch.Store(k)
+}
+
+// EngageKillSwitch stops all mutations in namespace, or in every namespace
+// if namespace is empty.
+func (api *API) EngageKillSwitch(namespace string) {
+	api.setKillSwitch(func(k *config.KillSwitch) {
+		if namespace == "" {
+			k.All = true
+			return
+		}
+		for _, ns := range k.Namespaces {
+			if ns == namespace {
+				return
+			}
+		}
+		k.Namespaces = append(k.Namespaces, namespace)
+	})
+}
+
+// ReleaseKillSwitch undoes EngageKillSwitch for namespace, or for every
+// namespace if namespace is empty. Namespaces engaged one by one stay
+// engaged until they are released one by one. The kill switch of the
+// configuration file is not affected.
+func (api *API) ReleaseKillSwitch(namespace string) {
+	api.setKillSwitch(func(k *config.KillSwitch) {
+		if namespace == "" {
+			k.All = false
+			return
+		}
+		namespaces := k.Namespaces[:0]
+		for _, ns := range k.Namespaces {
+			if ns != namespace {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		k.Namespaces = namespaces
+	})
+}
+
+// KillSwitchStatus returns the state of both kill switches.
+func (api *API) KillSwitchStatus() KillSwitchStatus {
+	status := KillSwitchStatus{Runtime: *api.runtimeKillSwitch()}
+	if k := api.snapshot().KillSwitch; k != nil {
+		status.Config = *k
+	}
+	return status
+}
+
+// killedBy returns the source of the kill switch that stops mutations in
+// namespace, or an empty source if none does.
+func (api *API) killedBy(conf *config.Config, namespace string) string {
+	if api.runtimeKillSwitch().Covers(namespace) {
+		return KillSwitchRuntime
+	}
+	if conf.KillSwitch.Covers(namespace) {
+		return KillSwitchConfig
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package admission
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateKillSwitch(t *testing.T) {
+	conf := &config.Config{
+		Mixedreslist: []*config.MixedRes{
+			{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10},
+			{Namespace: "batch", Name: "nginx", Mixed: true, Priority: 10},
+		},
+		KillSwitch: &config.KillSwitch{Namespaces: []string{"batch"}},
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+
+	mutated := func(namespace string) bool {
+		t.Helper()
+		resp := api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment(namespace, "nginx")))
+		if !resp.Allowed {
+			t.Fatalf("expected the request to be allowed, got %v", resp.Result)
+		}
+		return len(resp.Patch) > 0
+	}
+
+	if !mutated("default") || mutated("batch") {
+		t.Errorf("expected the config kill switch to stop mutations in batch only")
+	}
+
+	api.EngageKillSwitch("")
+	api.EngageKillSwitch("web")
+	api.EngageKillSwitch("web")
+	// A reload keeps the runtime kill switch.
+	api.Update(conf)
+	if mutated("default") {
+		t.Errorf("expected the runtime kill switch to stop all mutations")
+	}
+	want := KillSwitchStatus{
+		Runtime: config.KillSwitch{All: true, Namespaces: []string{"web"}},
+		Config:  config.KillSwitch{Namespaces: []string{"batch"}},
+	}
+	if status := api.KillSwitchStatus(); !reflect.DeepEqual(status, want) {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+	if n := testutil.CollectAndCount(api, "kubeadmission_webhook_kill_switch_engaged"); n != 3 {
+		t.Errorf("expected 3 engaged kill switch series, got %d", n)
+	}
+
+	api.ReleaseKillSwitch("")
+	if !mutated("default") || mutated("batch") {
+		t.Errorf("expected the runtime kill switch to be released")
+	}
+	api.ReleaseKillSwitch("web")
+	if status := api.KillSwitchStatus(); status.Runtime.All || len(status.Runtime.Namespaces) != 0 {
+		t.Errorf("runtime kill switch = %+v", status.Runtime)
+	}
+
+	if got := testutil.ToFloat64(api.killed.WithLabelValues(KillSwitchRuntime)); got != 1 {
+		t.Errorf("runtime kills = %v", got)
+	}
+	if got := testutil.ToFloat64(api.killed.WithLabelValues(KillSwitchConfig)); got != 2 {
+		t.Errorf("config kills = %v", got)
+	}
+}
@@ -0,0 +1,92 @@
+package admission
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+const namespace = "kubeadmission_webhook"
+
+var windowActiveDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "mixed_window_active"),
+	"Whether a mixed schedule window is currently active (1) or not (0).",
+	[]string{"namespace", "name", "window"}, nil,
+)
+
+var killSwitchEngagedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "kill_switch_engaged"),
+	"Whether a kill switch stops mutations (1). The namespace is \"*\" for a kill switch that covers every namespace.",
+	[]string{"source", "namespace"}, nil,
+)
+
+func newKilledCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kill_switch_requests_total",
+		Help:      "Requests allowed without changes because a kill switch was engaged, by the source of the kill switch.",
+	}, []string{"source"})
+}
+
+func newAuditedCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_requests_total",
+		Help:      "Requests of rules in audit mode, by what the rule would have done.",
+	}, []string{"outcome"})
+}
+
+func newFailuresCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Requests the webhook failed to handle, by failure class and the action the failure policy took.",
+	}, []string{"class", "action"})
+}
+
+// Describe implements prometheus.Collector.
+func (api *API) Describe(ch chan<- *prometheus.Desc) {
+	ch <- windowActiveDesc
+	ch <- killSwitchEngagedDesc
+	api.failures.Describe(ch)
+	api.killed.Describe(ch)
+	api.audited.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. Window state depends on the time
+// of the scrape, so it is computed here rather than tracked as requests come in.
+func (api *API) Collect(ch chan<- prometheus.Metric) {
+	api.failures.Collect(ch)
+	api.killed.Collect(ch)
+	api.audited.Collect(ch)
+
+	status := api.KillSwitchStatus()
+	for source, k := range map[string]config.KillSwitch{KillSwitchRuntime: status.Runtime, KillSwitchConfig: status.Config} {
+		if k.All {
+			ch <- prometheus.MustNewConstMetric(killSwitchEngagedDesc, prometheus.GaugeValue, 1, source, "*")
+		}
+		for _, ns := range k.Namespaces {
+			ch <- prometheus.MustNewConstMetric(killSwitchEngagedDesc, prometheus.GaugeValue, 1, source, ns)
+		}
+	}
+
+	conf := api.snapshot()
+	now := api.now()
+
+	collect := func(ns, name string, windows []*config.Window) {
+		for _, w := range windows {
+			active := 0.0
+			if w.Active(now) {
+				active = 1
+			}
+			ch <- prometheus.MustNewConstMetric(windowActiveDesc, prometheus.GaugeValue, active, ns, name, w.Name)
+		}
+	}
+	for _, rule := range conf.Mixedreslist {
+		collect(rule.Namespace, rule.Name, rule.Windows)
+	}
+	for _, ns := range conf.Namespaces {
+		collect(ns.Namespace, "", ns.Windows)
+	}
+	collect("", "", conf.Global.Windows)
+}
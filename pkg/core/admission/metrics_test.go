@@ -0,0 +1,71 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestCollectWindows(t *testing.T) {
+	night := func() []*config.Window {
+		return []*config.Window{{Name: "night", Start: "22:00", End: "06:00"}, {Name: "weekend", Weekdays: []string{"sat", "sun"}}}
+	}
+	conf := &config.Config{
+		Global:     config.Settings{Windows: night()},
+		Namespaces: []*config.NamespacePolicy{{Namespace: "batch", Settings: config.Settings{Windows: night()}}},
+		Mixedreslist: []*config.MixedRes{
+			{Namespace: "batch", Name: "job", Mixed: true, Priority: 10, Windows: night()},
+		},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+	api.now = func() time.Time { return time.Date(2022, 8, 1, 23, 0, 0, 0, time.UTC) }
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(api)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := 0
+	for _, family := range families {
+		if family.GetName() == "kubeadmission_webhook_mixed_window_active" {
+			series = len(family.GetMetric())
+		}
+	}
+	// The global windows, the windows of the namespace and those of the
+	// rule, each with two windows.
+	if series != 6 {
+		t.Errorf("expected 6 window series, got %d", series)
+	}
+}
+
+func TestCollectRejectsDuplicateRules(t *testing.T) {
+	rule := func(name string) *config.MixedRes {
+		return &config.MixedRes{Namespace: "batch", Name: name, Mixed: true, Priority: 10,
+			Windows: []*config.Window{{Name: "night", Start: "22:00", End: "06:00"}}}
+	}
+	duplicate := &config.Config{Mixedreslist: []*config.MixedRes{rule("job"), rule("job")}}
+	if err := duplicate.Validate(); err == nil {
+		t.Fatal("expected a workload listed twice to be rejected")
+	}
+
+	conf := &config.Config{Mixedreslist: []*config.MixedRes{rule("job"), rule("cron")}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(api)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("expected windows of the same name on different workloads to be collected, got %v", err)
+	}
+}
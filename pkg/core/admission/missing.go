@@ -0,0 +1,98 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// nativeResourceNames are the resources a mixed container mirrors to the
+// cmos.mixed extended resources, in the order they are reported.
+var nativeResourceNames = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// missingResources lists the cpu and memory requests and limits native
+// lacks, such as "requests.cpu".
+func missingResources(native corev1.ResourceRequirements) (missing []string) {
+	for _, name := range nativeResourceNames {
+		if _, ok := native.Requests[name]; !ok {
+			missing = append(missing, "requests."+string(name))
+		}
+		if _, ok := native.Limits[name]; !ok {
+			missing = append(missing, "limits."+string(name))
+		}
+	}
+	return missing
+}
+
+// completeResources fills in the values native lacks as the policy says. It
+// returns false if the container is to be left alone. Values that remain
+// missing are not mirrored.
+func completeResources(native corev1.ResourceRequirements, policy *config.MissingResources) (corev1.ResourceRequirements, bool) {
+	if len(missingResources(native)) == 0 {
+		return native, true
+	}
+	action := config.MissingResourcesLimits
+	if policy != nil && policy.Action != "" {
+		action = policy.Action
+	}
+
+	completed := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, name := range nativeResourceNames {
+		request, hasRequest := native.Requests[name]
+		limit, hasLimit := native.Limits[name]
+		switch action {
+		case config.MissingResourcesSkip, config.MissingResourcesDeny:
+			return native, false
+		case config.MissingResourcesDefaults:
+			if !hasRequest {
+				request, hasRequest = policy.Requests[name]
+			}
+			if !hasLimit {
+				limit, hasLimit = policy.Limits[name]
+			}
+		default:
+			if !hasRequest {
+				request, hasRequest = limit, hasLimit
+			} else if !hasLimit {
+				limit, hasLimit = request, hasRequest
+			}
+		}
+		if hasRequest {
+			completed.Requests[name] = request
+		}
+		if hasLimit {
+			completed.Limits[name] = limit
+		}
+	}
+	return completed, true
+}
+
+// missingResourcesReason returns why a mixed deployment is rejected by the
+// deny policy for missing resources, or an empty reason if it is not.
+func missingResourcesReason(rule *config.MixedRes, deployment *appsv1.Deployment) string {
+	if rule.MissingResources == nil || rule.MissingResources.Action != config.MissingResourcesDeny {
+		return ""
+	}
+	original := originalResources(&deployment.Spec.Template)
+	var problems []string
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		if !rule.Containers.Selected(c.Name) {
+			continue
+		}
+		if missing := missingResources(nativeResources(c, original)); len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("container %s has no %s", c.Name, strings.Join(missing, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("mixed workload %s/%s needs cpu and memory requests and limits: %s",
+		deployment.Namespace, deployment.Name, strings.Join(problems, "; "))
+}
@@ -0,0 +1,95 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateMissingResources(t *testing.T) {
+	requestsOnly := corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("500m"),
+	}}
+	limitsOnly := corev1.ResourceRequirements{Limits: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+	}}
+	defaults := &config.MissingResources{
+		Action:   config.MissingResourcesDefaults,
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+	}
+
+	tests := []struct {
+		name      string
+		policy    *config.MissingResources
+		resources corev1.ResourceRequirements
+
+		denied   bool
+		requests map[string]string
+		limits   map[string]string
+	}{
+		{
+			name: "limits fallback", resources: limitsOnly,
+			requests: map[string]string{ContainerResourceCpuKey: "2000", ContainerResourceMemoryKey: "1073741824", ContainerResourcePodCountKey: "1"},
+			limits:   map[string]string{ContainerResourceCpuKey: "2000", ContainerResourceMemoryKey: "1073741824", ContainerResourcePodCountKey: "1"},
+		},
+		{
+			name: "limits fallback never writes zero", resources: requestsOnly,
+			requests: map[string]string{ContainerResourceCpuKey: "500", ContainerResourcePodCountKey: "1"},
+			limits:   map[string]string{ContainerResourceCpuKey: "500", ContainerResourcePodCountKey: "1"},
+		},
+		{
+			name: "defaults", policy: defaults, resources: requestsOnly,
+			requests: map[string]string{ContainerResourceCpuKey: "500", ContainerResourceMemoryKey: "134217728", ContainerResourcePodCountKey: "1"},
+			limits:   map[string]string{ContainerResourceCpuKey: "500", ContainerResourceMemoryKey: "134217728", ContainerResourcePodCountKey: "1"},
+		},
+		{
+			name: "skip", policy: &config.MissingResources{Action: config.MissingResourcesSkip}, resources: requestsOnly,
+			requests: map[string]string{string(corev1.ResourceCPU): "500m"},
+			limits:   map[string]string{},
+		},
+		{
+			name: "deny", policy: &config.MissingResources{Action: config.MissingResourcesDeny}, resources: requestsOnly,
+			denied: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(log.NewNopLogger(), nil)
+			api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+				{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, MissingResources: tt.policy},
+			}})
+			d := newTestDeployment("default", "nginx")
+			d.Spec.Template.Spec.Containers[0].Resources = tt.resources
+
+			resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+			if tt.denied {
+				if resp.Allowed || !strings.Contains(resp.Result.Message, "container nginx has no limits.cpu, requests.memory, limits.memory") {
+					t.Fatalf("expected denial naming the missing resources, got %v", resp.Result)
+				}
+				return
+			}
+			c := applyResponse(t, d, resp).Spec.Template.Spec.Containers[0]
+			for _, got := range []struct {
+				list corev1.ResourceList
+				want map[string]string
+			}{{c.Resources.Requests, tt.requests}, {c.Resources.Limits, tt.limits}} {
+				if len(got.list) != len(got.want) {
+					t.Errorf("resources = %v, want %v", got.list, got.want)
+				}
+				for name, want := range got.want {
+					if q := got.list[corev1.ResourceName(name)]; q.Cmp(resource.MustParse(want)) != 0 {
+						t.Errorf("%s = %s, want %s", name, q.String(), want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -2,25 +2,132 @@ package admission
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+const (
+	// WorkloadAnnotationOptOutKey set to "true" on a workload keeps it off
+	// co-location nodes even if it is listed as mixed.
+	WorkloadAnnotationOptOutKey string = "hc/mixed-opt-out"
+	// WorkloadAnnotationOptInKey set to "true" on an unlisted workload asks
+	// for mixed mode, if the namespace policy allows it.
+	WorkloadAnnotationOptInKey string = "hc/mixed-opt-in"
+	// WorkloadAnnotationPriorityKey is the priority an opted-in workload asks
+	// for. It is capped by the namespace policy.
+	WorkloadAnnotationPriorityKey string = "hc/mixed-priority"
 )
 
-func (api *API) mutationRequired(metadata *metav1.ObjectMeta) (index int, required bool) {
+// mutationRequired looks the workload up in the given configuration snapshot
+// and returns the matching rule. The rule always belongs to conf, so callers
+// must keep using the same snapshot for the rest of the request. ignored
+// holds the errors of the workload and namespace annotations that were
+// consulted but invalid.
+func (api *API) mutationRequired(conf *config.Config, metadata *metav1.ObjectMeta) (rule *config.MixedRes, required bool, ignored []error) {
 	required = false
 	logger := log.With(api.logger, "admission", "mutationRequired")
 	level.Info(logger).Log("msg", "determine if a resource is in mixed list")
-	requirements := api.getRequiredList()
+	requirements := api.getRequiredList(conf)
 	res := metadata.Name + "/" + metadata.Namespace
 
 	for i, v := range requirements {
 		if res == v {
 			required = true
-			index = i
+			rule = conf.Mixedreslist[i]
+		}
+	}
+	var err error
+	if !required {
+		if rule, required, err = api.optIn(conf, metadata); err != nil {
+			ignored = append(ignored, err)
+		}
+	}
+	if !required {
+		if rule, required, err = api.namespaceAnnotationRule(conf, metadata); err != nil {
+			ignored = append(ignored, err)
+		}
+	}
+	if !required {
+		rule, required = conf.NamespaceRule(metadata.Namespace, metadata.Name)
+	}
+	if required && rule.Mixed && annotationEnabled(metadata, WorkloadAnnotationOptOutKey) {
+		level.Info(logger).Log("msg", fmt.Sprintf("%s/%s opted out of mixed mode", metadata.Name, metadata.Namespace))
+		rule = withSource(rule, "mixed", config.LayerAnnotation)
+		rule.Mixed = false
+	}
+	if required && rule.Mixed {
+		if window, active := config.ActiveWindow(rule.Windows, api.now()); !active {
+			level.Info(logger).Log("msg", fmt.Sprintf("%s/%s is outside of all its mixed windows", metadata.Name, metadata.Namespace))
+			rule = withSource(rule, "mixed", config.LayerSchedule)
+			rule.Mixed = false
+		} else if window != nil {
+			level.Debug(logger).Log("msg", "mixed window active", "window", window.Name)
 		}
 	}
 	level.Info(logger).Log("msg", fmt.Sprintf("mutation policy for %s/%s: required: %v", metadata.Name, metadata.Namespace, required))
-	return index, required
+	if required {
+		level.Debug(logger).Log("msg", "effective rule", "mixed", rule.Mixed, "priority", rule.Priority, "sources", fmt.Sprint(rule.Sources))
+	}
+	return rule, required, ignored
+}
+
+// optIn builds a rule for an unlisted workload that carries the opt-in
+// annotation, as far as the policy of its namespace allows. An opt-in with an
+// invalid priority annotation is ignored, and the validation error is
+// returned so that it can be reported on the request.
+func (api *API) optIn(conf *config.Config, metadata *metav1.ObjectMeta) (*config.MixedRes, bool, error) {
+	logger := log.With(api.logger, "admission", "optIn")
+	if !annotationEnabled(metadata, WorkloadAnnotationOptInKey) {
+		return nil, false, nil
+	}
+
+	policy := conf.Namespace(metadata.Namespace)
+	if policy == nil || !policy.AllowOptIn {
+		level.Info(logger).Log("msg", fmt.Sprintf("opt-in of %s/%s ignored: namespace does not allow opt-in", metadata.Name, metadata.Namespace))
+		return nil, false, nil
+	}
+
+	rule := withSource(conf.Inherited(metadata.Namespace, metadata.Name), "mixed", config.LayerAnnotation)
+	rule.Mixed = true
+	if rule.Sources["priority"] == config.LayerDefault {
+		rule.Priority = policy.MaxPriority
+		rule.Sources["priority"] = config.LayerNamespace
+	}
+	if value, ok := metadata.Annotations[WorkloadAnnotationPriorityKey]; ok {
+		requested, err := parsePriorityAnnotation(WorkloadAnnotationPriorityKey, value)
+		if err != nil {
+			level.Warn(logger).Log("msg", fmt.Sprintf("opt-in of %s/%s ignored", metadata.Name, metadata.Namespace), "err", err)
+			return nil, false, fmt.Errorf("opt-in of %s/%s: %w", metadata.Namespace, metadata.Name, err)
+		}
+		rule.Priority = requested
+		rule.Sources["priority"] = config.LayerAnnotation
+	}
+	if policy.MaxPriority != 0 && rule.Priority > policy.MaxPriority {
+		level.Info(logger).Log("msg", fmt.Sprintf("opt-in priority of %s/%s capped from %d to %d", metadata.Name, metadata.Namespace, rule.Priority, policy.MaxPriority))
+		rule.Priority = policy.MaxPriority
+		rule.Sources["priority"] = config.LayerNamespace
+	}
+	return rule, true, nil
+}
+
+// withSource returns a copy of rule whose field is marked as supplied by
+// layer, leaving the shared configuration untouched.
+func withSource(rule *config.MixedRes, field string, layer config.Layer) *config.MixedRes {
+	c := *rule
+	c.Sources = map[string]config.Layer{}
+	for k, v := range rule.Sources {
+		c.Sources[k] = v
+	}
+	c.Sources[field] = layer
+	return &c
+}
+
+func annotationEnabled(metadata *metav1.ObjectMeta, key string) bool {
+	enabled, _ := strconv.ParseBool(metadata.Annotations[key])
+	return enabled
 }
@@ -0,0 +1,217 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutationRequiredOptOutAndOptIn(t *testing.T) {
+	conf := &config.Config{
+		Mixedreslist: []*config.MixedRes{
+			{Namespace: "default", Name: "listed", Mixed: true, Priority: 100},
+		},
+		Namespaces: []*config.NamespacePolicy{
+			{Namespace: "batch", AllowOptIn: true, MaxPriority: 60},
+			{Namespace: "closed", AllowOptIn: false},
+		},
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+
+	tests := []struct {
+		name        string
+		namespace   string
+		workload    string
+		annotations map[string]string
+
+		required bool
+		mixed    bool
+		priority int64
+		ignored  bool
+	}{
+		{
+			name: "listed", namespace: "default", workload: "listed",
+			required: true, mixed: true, priority: 100,
+		},
+		{
+			name: "listed opt-out", namespace: "default", workload: "listed",
+			annotations: map[string]string{WorkloadAnnotationOptOutKey: "true"},
+			required:    true, mixed: false, priority: 100,
+		},
+		{
+			name: "unlisted", namespace: "batch", workload: "job",
+			required: false,
+		},
+		{
+			name: "opt-in without priority uses cap", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true"},
+			required:    true, mixed: true, priority: 60,
+		},
+		{
+			name: "opt-in priority below cap", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationPriorityKey: "20"},
+			required:    true, mixed: true, priority: 20,
+		},
+		{
+			name: "opt-in priority capped", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationPriorityKey: "90"},
+			required:    true, mixed: true, priority: 60,
+		},
+		{
+			name: "opt-in invalid priority", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationPriorityKey: "high"},
+			required:    false, ignored: true,
+		},
+		{
+			name: "opt-in negative priority", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationPriorityKey: "-1"},
+			required:    false, ignored: true,
+		},
+		{
+			name: "opt-in not allowed", namespace: "closed", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true"},
+			required:    false,
+		},
+		{
+			name: "opt-in without namespace policy", namespace: "other", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true"},
+			required:    false,
+		},
+		{
+			name: "opt-in and opt-out", namespace: "batch", workload: "job",
+			annotations: map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationOptOutKey: "true"},
+			required:    true, mixed: false, priority: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := &metav1.ObjectMeta{Namespace: tt.namespace, Name: tt.workload, Annotations: tt.annotations}
+			rule, required, ignored := api.mutationRequired(conf, meta)
+			if (len(ignored) > 0) != tt.ignored {
+				t.Errorf("ignored = %v, want ignored: %v", ignored, tt.ignored)
+			}
+			if required != tt.required {
+				t.Fatalf("required = %v, want %v", required, tt.required)
+			}
+			if !required {
+				return
+			}
+			if rule.Mixed != tt.mixed || rule.Priority != tt.priority {
+				t.Errorf("rule = {mixed: %v, priority: %d}, want {mixed: %v, priority: %d}", rule.Mixed, rule.Priority, tt.mixed, tt.priority)
+			}
+		})
+	}
+
+	if !conf.Mixedreslist[0].Mixed {
+		t.Errorf("opt-out must not modify the shared configuration")
+	}
+}
+
+func TestMutateInvalidOptIn(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.SetEventRecorder(recorder)
+	api.Update(&config.Config{Namespaces: []*config.NamespacePolicy{{Namespace: "batch", AllowOptIn: true}}})
+
+	d := newTestDeployment("batch", "job")
+	d.Annotations = map[string]string{WorkloadAnnotationOptInKey: "true", WorkloadAnnotationPriorityKey: "-5"}
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], WorkloadAnnotationPriorityKey) {
+		t.Fatalf("expected the workload to be allowed with a warning, got %v", resp)
+	}
+	if wasMixed(&applyResponse(t, d, resp).Spec.Template) {
+		t.Errorf("expected an opt-in with a negative priority to be ignored")
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, "Warning "+EventReasonSkipped+" ") {
+			t.Errorf("expected a skipped event, got %q", e)
+		}
+	default:
+		t.Errorf("expected a skipped event")
+	}
+}
+
+func TestMutateOptOutOfMixedWorkload(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10},
+	}})
+	original := newTestDeployment("default", "nginx")
+	mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+	if !wasMixed(&mixed.Spec.Template) {
+		t.Fatal("expected the workload to be made mixed")
+	}
+
+	optedOut := mixed.DeepCopy()
+	optedOut.Annotations = map[string]string{WorkloadAnnotationOptOutKey: "true"}
+	resp := api.mutate(newTestReview(t, admissionv1.Update, optedOut))
+	if !resp.Allowed {
+		t.Fatalf("expected the update to be allowed, got %v", resp.Result)
+	}
+	if strings.Contains(string(resp.Patch), "/spec/selector") {
+		t.Errorf("the selector of a deployment is immutable, got patch %s", resp.Patch)
+	}
+	restored := applyResponse(t, optedOut, resp)
+	if wasMixed(&restored.Spec.Template) {
+		t.Errorf("expected the opted-out workload to leave mixed mode, got %v", restored.Spec.Template)
+	}
+	if !equality.Semantic.DeepEqual(restored.Spec.Template.Spec, original.Spec.Template.Spec) {
+		t.Errorf("expected original pod spec to be restored:\n%v\n%v", original.Spec.Template.Spec, restored.Spec.Template.Spec)
+	}
+	if v := restored.Spec.Template.Labels[PodLabelMixedKey]; v != "false" {
+		t.Errorf("mixed label = %q, want false", v)
+	}
+}
+
+func TestMutationRequiredWindows(t *testing.T) {
+	conf := &config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "batch", Name: "job", Mixed: true, Priority: 10,
+		Windows: []*config.Window{{Name: "night", Start: "22:00", End: "06:00"}},
+	}}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+	meta := &metav1.ObjectMeta{Namespace: "batch", Name: "job"}
+
+	api.now = func() time.Time { return time.Date(2022, 8, 1, 23, 0, 0, 0, time.UTC) }
+	if rule, _, _ := api.mutationRequired(conf, meta); !rule.Mixed {
+		t.Errorf("expected mixed inside the window")
+	}
+	if n := testutil.CollectAndCount(api); n != 1 {
+		t.Errorf("expected 1 window metric, got %d", n)
+	}
+	expected := `
+# HELP kubeadmission_webhook_mixed_window_active Whether a mixed schedule window is currently active (1) or not (0).
+# TYPE kubeadmission_webhook_mixed_window_active gauge
+kubeadmission_webhook_mixed_window_active{name="job",namespace="batch",window="night"} 1
+`
+	if err := testutil.CollectAndCompare(api, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	api.now = func() time.Time { return time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC) }
+	rule, required, _ := api.mutationRequired(conf, meta)
+	if !required || rule.Mixed {
+		t.Errorf("expected non-mixed outside the window")
+	}
+	if rule.Sources["mixed"] != config.LayerSchedule {
+		t.Errorf("expected mixed to come from the schedule, got %q", rule.Sources["mixed"])
+	}
+	if !conf.Mixedreslist[0].Mixed {
+		t.Errorf("windows must not modify the shared configuration")
+	}
+}
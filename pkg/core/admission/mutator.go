@@ -0,0 +1,155 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// Object is what a Mutator works on: the workload under admission together
+// with the request, the rule it matched and the configuration snapshot the
+// rule belongs to.
+type Object struct {
+	Request *admissionv1.AdmissionRequest
+	Rule    *config.MixedRes
+	Config  *config.Config
+	// Deployment already has the patches of the mutators that ran before.
+	// Mutators must not modify it.
+	Deployment *appsv1.Deployment
+}
+
+// Mutator is one step of the mutation pipeline.
+type Mutator interface {
+	// Name identifies the mutator in the mutators list of a rule.
+	Name() string
+	// Applies reports whether the mutator has anything to do for the request
+	// and the rule it matched.
+	Applies(req *admissionv1.AdmissionRequest, rule *config.MixedRes) bool
+	// Mutate returns the patch for the object.
+	Mutate(obj *Object) ([]PatchOperation, error)
+}
+
+var (
+	registryMtx sync.RWMutex
+	registry    = map[string]Mutator{}
+)
+
+// DefaultMutators is the pipeline of a rule that does not list its own
+// mutators.
+var DefaultMutators = []string{
+	annotationsMutatorName,
+	labelsMutatorName,
+	nodeSelectorMutatorName,
+	sidecarsMutatorName,
+	containerResourcesMutatorName,
+	imageRewriteMutatorName,
+	unmixMutatorName,
+	podOverlayMutatorName,
+	patchesMutatorName,
+}
+
+// RegisterMutator makes a mutator available to rules by its name. It panics
+// if the name is already taken.
+func RegisterMutator(m Mutator) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	if _, ok := registry[m.Name()]; ok {
+		panic(fmt.Sprintf("admission: mutator %q registered twice", m.Name()))
+	}
+	registry[m.Name()] = m
+}
+
+func lookupMutator(name string) (Mutator, bool) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	m, ok := registry[name]
+	return m, ok
+}
+
+// pipeline returns the names of the mutators for rule, in order.
+func pipeline(rule *config.MixedRes) []string {
+	if len(rule.Mutators) > 0 {
+		return rule.Mutators
+	}
+	return DefaultMutators
+}
+
+// validateMutators checks that every mutator named in the configuration is
+// registered.
+func validateMutators(conf *config.Config) error {
+	lists := [][]string{conf.Global.Mutators}
+	for _, ns := range conf.Namespaces {
+		lists = append(lists, ns.Mutators)
+	}
+	for _, rule := range conf.Mixedreslist {
+		lists = append(lists, rule.Mutators)
+	}
+	for _, names := range lists {
+		for _, name := range names {
+			if _, ok := lookupMutator(name); !ok {
+				return fmt.Errorf("unknown mutator %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// runMutators runs the pipeline of the rule on the object. Each mutator sees
+// the object with the patches of the mutators before it applied. It returns
+// the patch together with the deployment it results in.
+func runMutators(obj *Object) (patch []PatchOperation, mutated *appsv1.Deployment, err error) {
+	current := *obj
+	for _, name := range pipeline(obj.Rule) {
+		m, ok := lookupMutator(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown mutator %q", name)
+		}
+		if !m.Applies(current.Request, current.Rule) {
+			continue
+		}
+		ops, err := m.Mutate(&current)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mutator %s: %w", name, err)
+		}
+		if len(ops) == 0 {
+			continue
+		}
+		if current.Deployment, err = applyPatch(current.Deployment, ops); err != nil {
+			return nil, nil, fmt.Errorf("mutator %s: %w", name, err)
+		}
+		patch = append(patch, ops...)
+	}
+	return patch, current.Deployment, nil
+}
+
+// applyPatch returns a copy of deployment with patch applied.
+func applyPatch(deployment *appsv1.Deployment, patch []PatchOperation) (*appsv1.Deployment, error) {
+	raw, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, err
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err = decoded.Apply(raw); err != nil {
+		return nil, err
+	}
+	patched := &appsv1.Deployment{}
+	if err := json.Unmarshal(raw, patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
@@ -0,0 +1,225 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// runMutator runs a single mutator the way the pipeline would and returns
+// the patched deployment, or nil if the mutator does not apply.
+func runMutator(t *testing.T, m Mutator, op admissionv1.Operation, rule *config.MixedRes, d *appsv1.Deployment) *appsv1.Deployment {
+	t.Helper()
+	req := &admissionv1.AdmissionRequest{Operation: op, Namespace: d.Namespace, Name: d.Name}
+	if !m.Applies(req, rule) {
+		return nil
+	}
+	patch, err := m.Mutate(&Object{Request: req, Rule: rule, Config: &config.Config{}, Deployment: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := applyPatch(d, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patched
+}
+
+func TestBuiltinMutators(t *testing.T) {
+	mixed := &config.MixedRes{Mixed: true, Priority: 7}
+	plain := &config.MixedRes{Mixed: false, Priority: 7}
+
+	d := runMutator(t, annotationsMutator{}, admissionv1.Create, plain, newTestDeployment("default", "nginx"))
+	if d.Spec.Template.Annotations[PodAnnotationPriorityKey] != "7" {
+		t.Errorf("annotations: got %v", d.Spec.Template.Annotations)
+	}
+
+	d = runMutator(t, labelsMutator{}, admissionv1.Create, mixed, newTestDeployment("default", "nginx"))
+	if d.Spec.Template.Labels[PodLabelMixedKey] != "true" || len(d.Spec.Selector.MatchLabels) != 1 {
+		t.Errorf("labels: got %v / %v", d.Spec.Template.Labels, d.Spec.Selector.MatchLabels)
+	}
+	// A selector that holds the label keeps the template label in line with it.
+	pinned := newTestDeployment("default", "nginx")
+	pinned.Spec.Selector.MatchLabels[PodLabelMixedKey] = "true"
+	pinned.Spec.Template.Labels[PodLabelMixedKey] = "true"
+	d = runMutator(t, labelsMutator{}, admissionv1.Update, plain, pinned)
+	if d.Spec.Template.Labels[PodLabelMixedKey] != "true" || d.Spec.Selector.MatchLabels[PodLabelMixedKey] != "true" {
+		t.Errorf("pinned labels: got %v / %v", d.Spec.Template.Labels, d.Spec.Selector.MatchLabels)
+	}
+
+	if runMutator(t, nodeSelectorMutator{}, admissionv1.Create, plain, newTestDeployment("default", "nginx")) != nil {
+		t.Errorf("node-selector must not apply to non-mixed rules")
+	}
+	d = runMutator(t, nodeSelectorMutator{}, admissionv1.Create, mixed, newTestDeployment("default", "nginx"))
+	if d.Spec.Template.Spec.NodeSelector[PodNodeSelectorKey] != "true" {
+		t.Errorf("node-selector: got %v", d.Spec.Template.Spec.NodeSelector)
+	}
+
+	d = runMutator(t, containerResourcesMutator{}, admissionv1.Create, mixed, newTestDeployment("default", "nginx"))
+	if _, ok := d.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceName(ContainerResourceCpuKey)]; !ok {
+		t.Errorf("container-resources: got %v", d.Spec.Template.Spec.Containers[0].Resources)
+	}
+	if _, ok := d.Spec.Template.Annotations[PodAnnotationOriginalResourcesKey]; !ok {
+		t.Errorf("container-resources must record the native resources")
+	}
+
+	if runMutator(t, unmixMutator{}, admissionv1.Create, plain, d) != nil {
+		t.Errorf("unmix must only apply on update")
+	}
+	if runMutator(t, unmixMutator{}, admissionv1.Update, mixed, d) != nil {
+		t.Errorf("unmix must not apply to mixed rules")
+	}
+	if d = runMutator(t, unmixMutator{}, admissionv1.Update, plain, d); wasMixed(&d.Spec.Template) {
+		t.Errorf("unmix: mixed markers left in %v", d.Spec.Template)
+	}
+}
+
+type testMutator struct {
+	name string
+	key  string
+}
+
+func (m testMutator) Name() string { return m.name }
+
+func (testMutator) Applies(*admissionv1.AdmissionRequest, *config.MixedRes) bool { return true }
+
+// Mutate appends the mutator name to an annotation, which shows both that
+// it ran and which mutators ran before it.
+func (m testMutator) Mutate(obj *Object) ([]PatchOperation, error) {
+	annotations := obj.Deployment.Spec.Template.Annotations
+	return mutatePodAnnotations(annotations, map[string]string{m.key: annotations[m.key] + m.name + ","}), nil
+}
+
+func TestMutatorPipeline(t *testing.T) {
+	for _, name := range []string{"test-first", "test-second"} {
+		if _, ok := lookupMutator(name); !ok {
+			RegisterMutator(testMutator{name: name, key: "test/order"})
+		}
+	}
+
+	api := NewAPI(log.NewNopLogger(), nil)
+	if err := api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mutators: []string{"no-such-mutator"}},
+	}}); err == nil {
+		t.Fatal("expected unknown mutator to be rejected")
+	}
+
+	if err := api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 1, Mutators: []string{"test-second", "labels", "test-first"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDeployment("default", "nginx")
+	patched := applyResponse(t, d, api.mutate(newTestReview(t, admissionv1.Create, d)))
+
+	if v := patched.Spec.Template.Annotations["test/order"]; v != "test-second,test-first," {
+		t.Errorf("order = %q, want test-second,test-first,", v)
+	}
+	if patched.Spec.Template.Labels[PodLabelMixedKey] != "true" {
+		t.Errorf("expected labels mutator to run")
+	}
+	if _, ok := patched.Spec.Template.Annotations[PodAnnotationPriorityKey]; ok {
+		t.Errorf("annotations mutator is not enabled for the rule")
+	}
+	if patched.Spec.Template.Spec.NodeSelector != nil {
+		t.Errorf("node-selector mutator is not enabled for the rule")
+	}
+}
+
+func TestRegisterMutatorTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	RegisterMutator(annotationsMutator{})
+}
+
+func TestPatchesMutator(t *testing.T) {
+	conf := &config.Config{Mixedreslist: []*config.MixedRes{{
+		Namespace: "default", Name: "nginx", Mixed: false, Priority: 60,
+		Patches: []*config.PatchTemplate{
+			{Op: "add", Path: "/spec/template/spec/containers/0/env", Value: []byte(`[{"name": "MIXED_PRIORITY", "value": "{{ .Rule.Priority }}"}]`)},
+			{Op: "add", Path: "/spec/template/metadata/labels/team", Value: []byte(`"{{ .Object.metadata.namespace }}-{{ .Request.operation }}"`)},
+		},
+	}}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(conf)
+
+	d := newTestDeployment("default", "nginx")
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed {
+		t.Fatalf("expected request to be allowed, got %v", resp.Result)
+	}
+	patched := applyResponse(t, d, resp)
+	env := patched.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "MIXED_PRIORITY" || env[0].Value != "60" {
+		t.Errorf("env = %v", env)
+	}
+	if v := patched.Spec.Template.Labels["team"]; v != "default-CREATE" {
+		t.Errorf("team label = %q", v)
+	}
+}
+
+func TestPodOverlayMutator(t *testing.T) {
+	rule := &config.MixedRes{Mixed: true, PodOverlay: []byte(`{
+		"schedulerName": "mixed-scheduler",
+		"runtimeClassName": "kata",
+		"securityContext": {"runAsNonRoot": true},
+		"containers": [
+			{"name": "*", "env": [{"name": "MIXED", "value": "true"}]},
+			{"name": "nginx", "env": [{"name": "ROLE", "value": "web"}]}
+		]
+	}`)}
+
+	d := newTestDeployment("default", "nginx")
+	d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, corev1.Container{
+		Name: "log", Image: "busybox", Env: []corev1.EnvVar{{Name: "LEVEL", Value: "info"}},
+	})
+	patched := runMutator(t, podOverlayMutator{}, admissionv1.Create, rule, d)
+
+	spec := patched.Spec.Template.Spec
+	if spec.SchedulerName != "mixed-scheduler" || spec.RuntimeClassName == nil || *spec.RuntimeClassName != "kata" {
+		t.Errorf("scheduler = %q, runtime class = %v", spec.SchedulerName, spec.RuntimeClassName)
+	}
+	if spec.SecurityContext == nil || spec.SecurityContext.RunAsNonRoot == nil || !*spec.SecurityContext.RunAsNonRoot {
+		t.Errorf("security context = %v", spec.SecurityContext)
+	}
+	if len(spec.Containers) != 2 {
+		t.Fatalf("expected the overlay not to add containers, got %d", len(spec.Containers))
+	}
+	env := map[string]map[string]string{}
+	for _, c := range spec.Containers {
+		env[c.Name] = map[string]string{}
+		for _, e := range c.Env {
+			env[c.Name][e.Name] = e.Value
+		}
+	}
+	if env["nginx"]["MIXED"] != "true" || env["nginx"]["ROLE"] != "web" {
+		t.Errorf("nginx env = %v", env["nginx"])
+	}
+	if env["log"]["MIXED"] != "true" || env["log"]["LEVEL"] != "info" || env["log"]["ROLE"] != "" {
+		t.Errorf("log env = %v", env["log"])
+	}
+	if spec.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("overlay must keep the fields it does not set, image = %q", spec.Containers[0].Image)
+	}
+
+	// A second pass over the merged template changes nothing.
+	patch, err := podOverlayMutator{}.Mutate(&Object{Rule: rule, Deployment: patched})
+	if err != nil || len(patch) != 0 {
+		t.Errorf("expected an idempotent overlay, got %v, %v", patch, err)
+	}
+
+	if runMutator(t, podOverlayMutator{}, admissionv1.Create, &config.MixedRes{}, d) != nil {
+		t.Errorf("pod-overlay must not apply without an overlay")
+	}
+}
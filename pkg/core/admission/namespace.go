@@ -0,0 +1,95 @@
+package admission
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+const (
+	// NamespaceAnnotationDefaultKey set to "true" on a namespace makes its
+	// unlisted workloads mixed, "false" makes them explicitly non-mixed.
+	NamespaceAnnotationDefaultKey string = "mixed.hc/default"
+	// NamespaceAnnotationPriorityKey is the priority of the unlisted
+	// workloads of a namespace. It is capped by the namespace policy.
+	NamespaceAnnotationPriorityKey string = "mixed.hc/priority"
+)
+
+// namespaceSettings reads the mixed settings from the annotations of a
+// namespace.
+func namespaceSettings(ns *corev1.Namespace) (settings config.Settings, err error) {
+	if value, ok := ns.Annotations[NamespaceAnnotationDefaultKey]; ok {
+		mixed, err := strconv.ParseBool(value)
+		if err != nil {
+			return settings, fmt.Errorf("namespace %s: annotation %s=%q is not a boolean", ns.Name, NamespaceAnnotationDefaultKey, value)
+		}
+		settings.Mixed = &mixed
+	}
+	if value, ok := ns.Annotations[NamespaceAnnotationPriorityKey]; ok {
+		priority, err := parsePriorityAnnotation(NamespaceAnnotationPriorityKey, value)
+		if err != nil {
+			return settings, fmt.Errorf("namespace %s: %w", ns.Name, err)
+		}
+		settings.Priority = &priority
+	}
+	return settings, nil
+}
+
+// parsePriorityAnnotation parses the value of a priority annotation, which
+// must be a non-negative integer.
+func parsePriorityAnnotation(key, value string) (int64, error) {
+	priority, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || priority < 0 {
+		return 0, fmt.Errorf("annotation %s=%q is not a non-negative integer", key, value)
+	}
+	return priority, nil
+}
+
+// namespaceAnnotationRule builds a rule for an unlisted workload from the
+// annotations of its namespace, read from the informer cache. Namespaces
+// with invalid annotations are ignored, and the validation error is returned
+// so that it can be reported on the request.
+func (api *API) namespaceAnnotationRule(conf *config.Config, metadata *metav1.ObjectMeta) (*config.MixedRes, bool, error) {
+	logger := log.With(api.logger, "admission", "namespaceAnnotationRule")
+	if api.cluster == nil {
+		return nil, false, nil
+	}
+	ns, err := api.cluster.Namespaces.Get(metadata.Namespace)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			level.Error(logger).Log("msg", "getting namespace failed", "namespace", metadata.Namespace, "err", err)
+		}
+		return nil, false, nil
+	}
+	settings, err := namespaceSettings(ns)
+	if err != nil {
+		level.Warn(logger).Log("msg", "namespace annotations ignored", "err", err)
+		return nil, false, err
+	}
+	if settings.Mixed == nil && settings.Priority == nil {
+		return nil, false, nil
+	}
+
+	rule := conf.Inherited(metadata.Namespace, metadata.Name)
+	if settings.Mixed != nil {
+		rule.Mixed = *settings.Mixed
+		rule.Sources["mixed"] = config.LayerNamespaceAnnotation
+	}
+	if settings.Priority != nil {
+		rule.Priority = *settings.Priority
+		rule.Sources["priority"] = config.LayerNamespaceAnnotation
+	}
+	if policy := conf.Namespace(metadata.Namespace); policy != nil && policy.MaxPriority != 0 && rule.Priority > policy.MaxPriority {
+		level.Info(logger).Log("msg", fmt.Sprintf("namespace priority of %s capped from %d to %d", metadata.Namespace, rule.Priority, policy.MaxPriority))
+		rule.Priority = policy.MaxPriority
+		rule.Sources["priority"] = config.LayerNamespace
+	}
+	return rule, true, nil
+}
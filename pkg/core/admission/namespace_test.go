@@ -0,0 +1,95 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func newTestNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestMutationRequiredNamespaceAnnotations(t *testing.T) {
+	conf := &config.Config{
+		Mixedreslist: []*config.MixedRes{{Namespace: "batch", Name: "listed", Mixed: false, Priority: 5}},
+		Namespaces:   []*config.NamespacePolicy{{Namespace: "capped", MaxPriority: 50}},
+	}
+	api := NewAPI(log.NewNopLogger(), newTestCluster(t, false,
+		newTestNamespace("batch", map[string]string{NamespaceAnnotationDefaultKey: "true", NamespaceAnnotationPriorityKey: "60"}),
+		newTestNamespace("capped", map[string]string{NamespaceAnnotationDefaultKey: "true", NamespaceAnnotationPriorityKey: "90"}),
+		newTestNamespace("priority-only", map[string]string{NamespaceAnnotationPriorityKey: "30"}),
+		newTestNamespace("invalid", map[string]string{NamespaceAnnotationDefaultKey: "yes please"}),
+		newTestNamespace("negative", map[string]string{NamespaceAnnotationDefaultKey: "true", NamespaceAnnotationPriorityKey: "-1"}),
+		newTestNamespace("plain", nil),
+	))
+
+	tests := []struct {
+		namespace, name string
+
+		required bool
+		mixed    bool
+		priority int64
+		source   config.Layer
+	}{
+		{"batch", "job", true, true, 60, config.LayerNamespaceAnnotation},
+		// Rules built in code carry no sources.
+		{"batch", "listed", true, false, 5, ""},
+		{"capped", "job", true, true, 50, config.LayerNamespaceAnnotation},
+		{"priority-only", "job", true, false, 30, config.LayerDefault},
+		{"invalid", "job", false, false, 0, ""},
+		{"negative", "job", false, false, 0, ""},
+		{"plain", "job", false, false, 0, ""},
+		{"missing", "job", false, false, 0, ""},
+	}
+	for _, tt := range tests {
+		rule, required, ignored := api.mutationRequired(conf, &metav1.ObjectMeta{Namespace: tt.namespace, Name: tt.name})
+		if invalid := tt.namespace == "invalid" || tt.namespace == "negative"; (len(ignored) > 0) != invalid {
+			t.Errorf("%s/%s: ignored = %v", tt.namespace, tt.name, ignored)
+		}
+		if required != tt.required {
+			t.Errorf("%s/%s: required = %v, want %v", tt.namespace, tt.name, required, tt.required)
+			continue
+		}
+		if !required {
+			continue
+		}
+		if rule.Mixed != tt.mixed || rule.Priority != tt.priority || rule.Sources["mixed"] != tt.source {
+			t.Errorf("%s/%s: rule = {mixed: %v, priority: %d, source: %q}, want {mixed: %v, priority: %d, source: %q}",
+				tt.namespace, tt.name, rule.Mixed, rule.Priority, rule.Sources["mixed"], tt.mixed, tt.priority, tt.source)
+		}
+	}
+}
+
+func TestMutateInvalidNamespaceAnnotations(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	api := NewAPI(log.NewNopLogger(), newTestCluster(t, false,
+		newTestNamespace("batch", map[string]string{NamespaceAnnotationDefaultKey: "yes please"}),
+	))
+	api.SetEventRecorder(recorder)
+	api.Update(&config.Config{})
+
+	d := newTestDeployment("batch", "job")
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], NamespaceAnnotationDefaultKey) {
+		t.Fatalf("expected the workload to be allowed with a warning, got %v", resp)
+	}
+	if wasMixed(&applyResponse(t, d, resp).Spec.Template) {
+		t.Errorf("expected invalid namespace annotations to be ignored")
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, "Warning "+EventReasonSkipped+" ") {
+			t.Errorf("expected a skipped event, got %q", e)
+		}
+	default:
+		t.Errorf("expected a skipped event")
+	}
+}
@@ -0,0 +1,260 @@
+package admission
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// PodAnnotationOverlaidFieldsKey records the original values of the pod
+// spec fields a pod overlay changed.
+const PodAnnotationOverlaidFieldsKey string = "hc/overlaid-fields"
+
+// OverlayAllContainers is the container name that makes an overlay entry
+// apply to every container of the pod.
+const OverlayAllContainers = "*"
+
+// splitOverlay separates the container entries named OverlayAllContainers
+// from the rest of the overlay and expands them to one entry per container
+// of the pod. Both returned patches may be nil.
+func splitOverlay(overlay json.RawMessage, containers []corev1.Container) (all, rest []byte, err error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(overlay, &spec); err != nil {
+		return nil, nil, err
+	}
+	entries, _ := spec["containers"].([]interface{})
+
+	var wildcard, named []interface{}
+	for _, e := range entries {
+		if entry, ok := e.(map[string]interface{}); ok && entry["name"] == OverlayAllContainers {
+			wildcard = append(wildcard, entry)
+		} else {
+			named = append(named, e)
+		}
+	}
+	if len(wildcard) == 0 {
+		return nil, overlay, nil
+	}
+
+	var expanded []interface{}
+	for _, c := range containers {
+		for _, w := range wildcard {
+			entry := map[string]interface{}{}
+			for k, v := range w.(map[string]interface{}) {
+				entry[k] = v
+			}
+			entry["name"] = c.Name
+			expanded = append(expanded, entry)
+		}
+	}
+	if all, err = json.Marshal(map[string]interface{}{"containers": expanded}); err != nil {
+		return nil, nil, err
+	}
+
+	if named == nil {
+		delete(spec, "containers")
+	} else {
+		spec["containers"] = named
+	}
+	if len(spec) > 0 {
+		if rest, err = json.Marshal(spec); err != nil {
+			return nil, nil, err
+		}
+	}
+	return all, rest, nil
+}
+
+// overlaidField is the record of one pod spec field an overlay changed.
+// Original is nil for a field the pod spec did not set.
+type overlaidField struct {
+	Original interface{} `json:"original,omitempty"`
+	Applied  interface{} `json:"applied"`
+}
+
+// overlayContainerLists are the pod spec fields whose containers an overlay
+// changes field by field.
+var overlayContainerLists = []string{"initContainers", "containers"}
+
+// originalOverlay returns the fields recorded on a template by an earlier
+// overlay, keyed by field. A field of a container is keyed by the container
+// list, the container name and the field, such as "containers/nginx/env".
+func originalOverlay(template *corev1.PodTemplateSpec) map[string]overlaidField {
+	original := map[string]overlaidField{}
+	if value, ok := template.Annotations[PodAnnotationOverlaidFieldsKey]; ok {
+		// A broken annotation is treated as absent.
+		_ = json.Unmarshal([]byte(value), &original)
+	}
+	return original
+}
+
+// overlayFields flattens a pod spec into the fields an overlay is recorded
+// by.
+func overlayFields(spec map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for key, value := range spec {
+		if !isOverlayContainerList(key) {
+			fields[key] = value
+			continue
+		}
+		containers, _ := value.([]interface{})
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := container["name"].(string)
+			for field, v := range container {
+				if field != "name" {
+					fields[key+"/"+name+"/"+field] = v
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// setOverlayField sets a field of a pod spec by the key overlayFields
+// returns for it. A nil value removes the field.
+func setOverlayField(spec map[string]interface{}, key string, value interface{}) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || !isOverlayContainerList(parts[0]) {
+		if value == nil {
+			delete(spec, key)
+		} else {
+			spec[key] = value
+		}
+		return
+	}
+	containers, _ := spec[parts[0]].([]interface{})
+	for _, c := range containers {
+		if container, ok := c.(map[string]interface{}); ok && container["name"] == parts[1] {
+			if value == nil {
+				delete(container, parts[2])
+			} else {
+				container[parts[2]] = value
+			}
+		}
+	}
+}
+
+func isOverlayContainerList(key string) bool {
+	for _, list := range overlayContainerLists {
+		if key == list {
+			return true
+		}
+	}
+	return false
+}
+
+// podSpecMap returns the pod spec of the template as generic JSON.
+func podSpecMap(template *corev1.PodTemplateSpec) (map[string]interface{}, error) {
+	raw, err := json.Marshal(template.Spec)
+	if err != nil {
+		return nil, err
+	}
+	spec := map[string]interface{}{}
+	return spec, json.Unmarshal(raw, &spec)
+}
+
+// withoutOverlay returns the pod spec of the template with the recorded
+// original values put back in the fields that still hold what the overlay
+// set, so that updates of an overlaid workload are stable.
+func withoutOverlay(template *corev1.PodTemplateSpec) (current, base map[string]interface{}, err error) {
+	if current, err = podSpecMap(template); err != nil {
+		return nil, nil, err
+	}
+	if base, err = podSpecMap(template); err != nil {
+		return nil, nil, err
+	}
+	fields := overlayFields(current)
+	for key, f := range originalOverlay(template) {
+		if reflect.DeepEqual(fields[key], f.Applied) {
+			setOverlayField(base, key, f.Original)
+		}
+	}
+	return current, base, nil
+}
+
+// mutatePodOverlay strategic-merges overlay into the pod spec of the template
+// and returns the patch for the fields that changed. The original values of
+// those fields are recorded, and fields an earlier overlay changed but this
+// one does not are put back.
+func mutatePodOverlay(deployment *appsv1.Deployment, overlay json.RawMessage) ([]PatchOperation, error) {
+	template := &deployment.Spec.Template
+	current, base, err := withoutOverlay(template)
+	if err != nil {
+		return nil, err
+	}
+	original, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := original
+	if len(overlay) > 0 {
+		all, rest, err := splitOverlay(overlay, template.Spec.Containers)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range [][]byte{all, rest} {
+			if p == nil {
+				continue
+			}
+			if merged, err = strategicpatch.StrategicMergePatch(merged, p, corev1.PodSpec{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var to map[string]interface{}
+	if err := json.Unmarshal(merged, &to); err != nil {
+		return nil, err
+	}
+	from, applied := overlayFields(base), overlayFields(to)
+	record := map[string]overlaidField{}
+	for key, value := range applied {
+		if !reflect.DeepEqual(from[key], value) {
+			record[key] = overlaidField{Original: from[key], Applied: value}
+		}
+	}
+	for key, value := range from {
+		if _, ok := applied[key]; !ok {
+			record[key] = overlaidField{Original: value}
+		}
+	}
+
+	patch := jsonDiff("/spec/template/spec", current, to)
+	if len(record) > 0 {
+		value, _ := json.Marshal(record)
+		if template.Annotations[PodAnnotationOverlaidFieldsKey] == string(value) {
+			return patch, nil
+		}
+		return append(patch, mutatePodAnnotations(template.Annotations, map[string]string{
+			PodAnnotationOverlaidFieldsKey: string(value),
+		})...), nil
+	}
+	if _, ok := template.Annotations[PodAnnotationOverlaidFieldsKey]; ok {
+		patch = append(patch, PatchOperation{
+			Op:   "remove",
+			Path: "/spec/template/metadata/annotations/" + escapeJSONPointer(PodAnnotationOverlaidFieldsKey),
+		})
+	}
+	return patch, nil
+}
+
+// restoreOverlay returns the patch that puts back the recorded original
+// values of the fields that still hold what the overlay set.
+func restoreOverlay(template *corev1.PodTemplateSpec) []PatchOperation {
+	if _, ok := template.Annotations[PodAnnotationOverlaidFieldsKey]; !ok {
+		return nil
+	}
+	current, base, err := withoutOverlay(template)
+	if err != nil {
+		return nil
+	}
+	return jsonDiff("/spec/template/spec", current, base)
+}
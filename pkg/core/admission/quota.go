@@ -0,0 +1,102 @@
+package admission
+
+import (
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+// mixedUsage sums the mixed cpu and memory requests of a deployment over its
+// containers and multiplies them by its replicas. requests returns the mixed
+// requests of a single container. The usage is in native units.
+func mixedUsage(deployment *appsv1.Deployment, requests func(corev1.Container) corev1.ResourceList, conv *config.Conversion) (cpu, memory resource.Quantity) {
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		reqs := requests(c)
+		cpu.Add(fromExtended(corev1.ResourceCPU, reqs[corev1.ResourceName(ContainerResourceCpuKey)], conv))
+		memory.Add(fromExtended(corev1.ResourceMemory, reqs[corev1.ResourceName(ContainerResourceMemoryKey)], conv))
+	}
+	replicas := int64(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = int64(*deployment.Spec.Replicas)
+	}
+	cpu = *resource.NewMilliQuantity(cpu.MilliValue()*replicas, resource.DecimalSI)
+	memory = *resource.NewQuantity(memory.Value()*replicas, resource.BinarySI)
+	return cpu, memory
+}
+
+// existingRequests returns the mixed requests a container already carries.
+func existingRequests(c corev1.Container) corev1.ResourceList {
+	return c.Resources.Requests
+}
+
+// checkQuota reports whether making deployment mixed would exceed the quota of
+// its namespace, counting every other workload of the namespace that is
+// already mixed. The requests of deployment are counted as rule would mirror
+// them. It returns the configured action and the reason, or an empty
+// reason if the workload fits. The quota is in native units.
+func (api *API) checkQuota(conf *config.Config, rule *config.MixedRes, deployment *appsv1.Deployment) (config.QuotaAction, string) {
+	logger := log.With(api.logger, "admission", "quota")
+	policy := conf.Namespace(deployment.Namespace)
+	if policy == nil || policy.Quota == nil {
+		return "", ""
+	}
+	if api.cluster == nil {
+		level.Warn(logger).Log("msg", fmt.Sprintf("mixed quota of namespace %s not enforced: no cluster informers", deployment.Namespace))
+		return "", ""
+	}
+
+	others, err := api.cluster.Deployments.Deployments(deployment.Namespace).List(labels.Everything())
+	if err != nil {
+		level.Error(logger).Log("msg", "listing deployments failed", "err", err)
+		return "", ""
+	}
+	var usedCPU, usedMemory resource.Quantity
+	for _, d := range others {
+		if d.Name == deployment.Name {
+			continue
+		}
+		cpu, memory := mixedUsage(d, existingRequests, conf.Conversion)
+		usedCPU.Add(cpu)
+		usedMemory.Add(memory)
+	}
+	original := originalResources(&deployment.Spec.Template)
+	cpu, memory := mixedUsage(deployment, func(c corev1.Container) corev1.ResourceList {
+		if !rule.Containers.Selected(c.Name) {
+			return nil
+		}
+		resources, ok := completeResources(nativeResources(c, original), rule.MissingResources)
+		if !ok {
+			return nil
+		}
+		return mirroredResources(resources, conf.Conversion)
+	}, conf.Conversion)
+
+	action := policy.Quota.Action
+	if action == "" {
+		action = config.QuotaActionFallback
+	}
+	exceeds := func(used, requested resource.Quantity, limit *resource.Quantity) bool {
+		if limit == nil {
+			return false
+		}
+		used.Add(requested)
+		return used.Cmp(*limit) > 0
+	}
+	switch {
+	case exceeds(usedCPU, cpu, policy.Quota.CPU):
+		return action, fmt.Sprintf("mixed quota of namespace %s exceeded: %s used %s + requested %s > %s",
+			deployment.Namespace, ContainerResourceCpuKey, usedCPU.String(), cpu.String(), policy.Quota.CPU.String())
+	case exceeds(usedMemory, memory, policy.Quota.Memory):
+		return action, fmt.Sprintf("mixed quota of namespace %s exceeded: %s used %s + requested %s > %s",
+			deployment.Namespace, ContainerResourceMemoryKey, usedMemory.String(), memory.String(), policy.Quota.Memory.String())
+	}
+	level.Debug(logger).Log("msg", "mixed quota check passed", "namespace", deployment.Namespace, "used_cpu", usedCPU.String(), "used_memory", usedMemory.String())
+	return "", ""
+}
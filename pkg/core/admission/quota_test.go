@@ -0,0 +1,150 @@
+package admission
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/cluster"
+)
+
+// newTestCluster returns a Cluster backed by a fake clientset holding objs,
+// with its caches synced. watchNodes makes it watch nodes as well.
+func newTestCluster(t *testing.T, watchNodes bool, objs ...runtime.Object) *cluster.Cluster {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c := cluster.New(fake.NewSimpleClientset(objs...), time.Minute, nil)
+	if watchNodes {
+		c.WatchNodes()
+	}
+	if err := c.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newMixedDeployment returns a deployment that the webhook already made
+// mixed, with the given native cpu request per replica.
+func newMixedDeployment(namespace, name string, replicas int32, cpu string) *appsv1.Deployment {
+	d := newTestDeployment(namespace, name)
+	d.Spec.Replicas = &replicas
+	c := &d.Spec.Template.Spec.Containers[0]
+	c.Resources.Requests[corev1.ResourceName(ContainerResourceCpuKey)] = toExtended(corev1.ResourceCPU, resource.MustParse(cpu), nil)
+	c.Resources.Requests[corev1.ResourceName(ContainerResourceMemoryKey)] = toExtended(corev1.ResourceMemory, resource.MustParse("1Gi"), nil)
+	return d
+}
+
+func TestMixedUsage(t *testing.T) {
+	d := newMixedDeployment("batch", "a", 3, "500m")
+	cpu, memory := mixedUsage(d, existingRequests, nil)
+	if cpu.Cmp(resource.MustParse("1500m")) != 0 {
+		t.Errorf("cpu = %s, want 1500m", cpu.String())
+	}
+	if memory.Cmp(resource.MustParse("3Gi")) != 0 {
+		t.Errorf("memory = %s, want 3Gi", memory.String())
+	}
+
+	// The fresh deployment has 1 cpu and 512Mi requested natively.
+	d = newTestDeployment("batch", "b")
+	cpu, memory = mixedUsage(d, func(c corev1.Container) corev1.ResourceList {
+		return mirroredResources(nativeResources(c, nil), nil)
+	}, nil)
+	if cpu.Cmp(resource.MustParse("1")) != 0 || memory.Cmp(resource.MustParse("512Mi")) != 0 {
+		t.Errorf("usage = %s/%s, want 1/512Mi", cpu.String(), memory.String())
+	}
+}
+
+func TestMutateQuota(t *testing.T) {
+	existing := []runtime.Object{
+		newMixedDeployment("batch", "a", 2, "1"),
+		newMixedDeployment("batch", "b", 1, "500m"),
+		// Not mixed, does not count.
+		newTestDeployment("batch", "c"),
+		// The workload under admission itself is replaced by its new spec.
+		newMixedDeployment("batch", "job", 4, "1"),
+	}
+	newConf := func(action config.QuotaAction) *config.Config {
+		cpu := resource.MustParse("3")
+		mixed := true
+		conf := &config.Config{Namespaces: []*config.NamespacePolicy{{
+			Namespace: "batch",
+			Settings:  config.Settings{Mixed: &mixed},
+			Quota:     &config.Quota{CPU: &cpu, Action: action},
+		}}}
+		if err := conf.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+
+	tests := []struct {
+		name     string
+		action   config.QuotaAction
+		replicas int32
+
+		allowed bool
+		mixed   bool
+	}{
+		{"fits", config.QuotaActionDeny, 0, true, true},
+		{"exceeded with deny", config.QuotaActionDeny, 1, false, false},
+		{"exceeded with fallback", config.QuotaActionFallback, 1, true, false},
+		{"exceeded with default action", "", 2, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(log.NewNopLogger(), newTestCluster(t, false, existing...))
+			api.Update(newConf(tt.action))
+
+			// 2.5 cpu are used by a and b, each replica of job requests 1.
+			d := newTestDeployment("batch", "job")
+			d.Spec.Replicas = &tt.replicas
+			resp := api.mutate(newTestReview(t, admissionv1.Update, d))
+
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v: %v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed {
+				if resp.Result.Code != http.StatusForbidden || !strings.Contains(resp.Result.Message, "quota") {
+					t.Errorf("unexpected result %v", resp.Result)
+				}
+				return
+			}
+			patched := applyResponse(t, d, resp)
+			if mixed := patched.Spec.Template.Labels[PodLabelMixedKey] == "true"; mixed != tt.mixed {
+				t.Errorf("mixed = %v, want %v", mixed, tt.mixed)
+			}
+			if !tt.mixed && (len(resp.Warnings) == 0 || resp.Result == nil || !strings.Contains(resp.Result.Message, "quota")) {
+				t.Errorf("expected the quota reason in the response, got %v %v", resp.Warnings, resp.Result)
+			}
+		})
+	}
+}
+
+func TestMutateQuotaWithoutCluster(t *testing.T) {
+	cpu := resource.MustParse("1m")
+	mixed := true
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(&config.Config{Namespaces: []*config.NamespacePolicy{{
+		Namespace: "batch",
+		Settings:  config.Settings{Mixed: &mixed},
+		Quota:     &config.Quota{CPU: &cpu, Action: config.QuotaActionDeny},
+	}}})
+
+	resp := api.mutate(newTestReview(t, admissionv1.Create, newTestDeployment("batch", "job")))
+	if !resp.Allowed {
+		t.Fatalf("expected quota to be skipped without informers, got %v", resp.Result)
+	}
+}
@@ -0,0 +1,145 @@
+package admission
+
+import (
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mixedResourceNames are the extended resources a mixed mutation adds.
+var mixedResourceNames = []corev1.ResourceName{
+	corev1.ResourceName(ContainerResourceCpuKey),
+	corev1.ResourceName(ContainerResourceMemoryKey),
+	corev1.ResourceName(ContainerResourcePodCountKey),
+}
+
+// wasMixed reports whether a template carries the markers an earlier mixed
+// mutation leaves behind.
+func wasMixed(template *corev1.PodTemplateSpec) bool {
+	if _, ok := template.Spec.NodeSelector[PodNodeSelectorKey]; ok {
+		return true
+	}
+	if _, ok := template.Annotations[PodAnnotationOriginalResourcesKey]; ok {
+		return true
+	}
+	if _, ok := template.Annotations[PodAnnotationOriginalImagesKey]; ok {
+		return true
+	}
+	if _, ok := template.Annotations[PodAnnotationInjectedSidecarsKey]; ok {
+		return true
+	}
+	if _, ok := template.Annotations[PodAnnotationOverlaidFieldsKey]; ok {
+		return true
+	}
+	for _, c := range template.Spec.Containers {
+		if hasMixedResources(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMixedResources reports whether a container requests or limits any of
+// the mixed extended resources.
+func hasMixedResources(c corev1.Container) bool {
+	for _, name := range mixedResourceNames {
+		if _, ok := c.Resources.Requests[name]; ok {
+			return true
+		}
+		if _, ok := c.Resources.Limits[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreResources returns a copy of list without the mixed resources and
+// with the recorded native ones, unless the container sets them again itself.
+func restoreResources(list corev1.ResourceList, original corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for name, q := range original {
+		result[name] = q
+	}
+	for name, q := range list {
+		result[name] = q
+	}
+	for _, name := range mixedResourceNames {
+		delete(result, name)
+	}
+	return result
+}
+
+// restoreContainerResources returns the patch that takes the container at
+// index back to its recorded native resources.
+func restoreContainerResources(index int, c corev1.Container, original map[string]corev1.ResourceRequirements) (patch []PatchOperation) {
+	requests := restoreResources(c.Resources.Requests, original[c.Name].Requests)
+	if (len(requests) > 0 || len(c.Resources.Requests) > 0) && !reflect.DeepEqual(requests, c.Resources.Requests) {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/requests", index),
+			Value: requests,
+		})
+	}
+	limits := restoreResources(c.Resources.Limits, original[c.Name].Limits)
+	if (len(limits) > 0 || len(c.Resources.Limits) > 0) && !reflect.DeepEqual(limits, c.Resources.Limits) {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/spec/template/spec/containers/%d/resources/limits", index),
+			Value: limits,
+		})
+	}
+	return patch
+}
+
+// unmutateMixed returns the patch that takes a template back out of mixed
+// mode: it removes the mixed node selector and extended resources and
+// restores the native resources recorded by mutateContainerResource and the
+// images recorded by mutateImages and the pod spec fields recorded by
+// mutatePodOverlay, and removes the injected sidecars. Unless
+// listed, that is a non-mixed rule still sets them, it also removes the
+// mixed label and the priority annotation. The selector is left alone.
+func unmutateMixed(deployment *appsv1.Deployment, listed bool) (patch []PatchOperation) {
+	template := &deployment.Spec.Template
+	// The overlay may replace whole container lists, so it is undone before
+	// the patches for single container fields.
+	patch = append(patch, restoreOverlay(template)...)
+	if _, ok := template.Spec.NodeSelector[PodNodeSelectorKey]; ok {
+		patch = append(patch, PatchOperation{
+			Op:   "remove",
+			Path: "/spec/template/spec/nodeSelector/" + escapeJSONPointer(PodNodeSelectorKey),
+		})
+	}
+
+	original := originalResources(template)
+	for index, c := range template.Spec.Containers {
+		patch = append(patch, restoreContainerResources(index, c, original)...)
+	}
+
+	patch = append(patch, restoreImages(template)...)
+	patch = append(patch, mutateSidecars(deployment, nil)...)
+
+	if _, ok := template.Labels[PodLabelMixedKey]; ok && !listed {
+		if _, pinned := selectorLabel(deployment, PodLabelMixedKey); !pinned {
+			patch = append(patch, PatchOperation{
+				Op:   "remove",
+				Path: "/spec/template/metadata/labels/" + escapeJSONPointer(PodLabelMixedKey),
+			})
+		}
+	}
+
+	keys := []string{PodAnnotationOriginalResourcesKey, PodAnnotationOriginalImagesKey, PodAnnotationOverlaidFieldsKey}
+	if !listed {
+		keys = append(keys, PodAnnotationPriorityKey)
+	}
+	for _, key := range keys {
+		if _, ok := template.Annotations[key]; ok {
+			patch = append(patch, PatchOperation{
+				Op:   "remove",
+				Path: "/spec/template/metadata/annotations/" + escapeJSONPointer(key),
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func TestMutateLeavesMixedMode(t *testing.T) {
+	mixedConf := &config.Config{Mixedreslist: []*config.MixedRes{
+		{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10},
+	}}
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(mixedConf)
+
+	original := newTestDeployment("default", "nginx")
+	original.Spec.Template.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": "amd64"}
+	original.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceEphemeralStorage] = resource.MustParse("1Gi")
+	mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+
+	c := mixed.Spec.Template.Spec.Containers[0]
+	if _, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+		t.Errorf("expected native cpu request to be moved, got %v", c.Resources.Requests)
+	}
+	if q := c.Resources.Requests[corev1.ResourceName(ContainerResourceCpuKey)]; q.Cmp(resource.MustParse("1000")) != 0 {
+		t.Errorf("mixed cpu request = %s, want 1000", q.String())
+	}
+	if _, ok := c.Resources.Limits[corev1.ResourceEphemeralStorage]; !ok {
+		t.Errorf("expected other limits to be kept, got %v", c.Resources.Limits)
+	}
+	if mixed.Spec.Template.Spec.NodeSelector["kubernetes.io/arch"] != "amd64" {
+		t.Errorf("expected existing node selector to be kept, got %v", mixed.Spec.Template.Spec.NodeSelector)
+	}
+
+	// Updating a mixed workload keeps its mixed resources stable.
+	again := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if !equality.Semantic.DeepEqual(again.Spec.Template, mixed.Spec.Template) {
+		t.Errorf("second mutation changed the template:\n%v\n%v", mixed.Spec.Template, again.Spec.Template)
+	}
+
+	for _, tt := range []struct {
+		name string
+		conf *config.Config
+		// labels and annotations are the template metadata the workload
+		// ends up with.
+		labels, annotations map[string]string
+	}{
+		{
+			name:        "flipped",
+			conf:        &config.Config{Mixedreslist: []*config.MixedRes{{Namespace: "default", Name: "nginx", Mixed: false, Priority: 10}}},
+			labels:      map[string]string{"app": "nginx", PodLabelMixedKey: "false"},
+			annotations: map[string]string{PodAnnotationPriorityKey: "10"},
+		},
+		{
+			name:   "removed",
+			conf:   &config.Config{},
+			labels: original.Spec.Template.Labels,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api.Update(tt.conf)
+			restored := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+
+			if wasMixed(&restored.Spec.Template) {
+				t.Errorf("expected all mixed markers to be removed, got %v", restored.Spec.Template)
+			}
+			if !equality.Semantic.DeepEqual(restored.Spec.Template.Spec, original.Spec.Template.Spec) {
+				t.Errorf("expected original pod spec to be restored:\n%v\n%v", original.Spec.Template.Spec, restored.Spec.Template.Spec)
+			}
+			if !equality.Semantic.DeepEqual(restored.Spec.Selector, original.Spec.Selector) {
+				t.Errorf("expected the selector to be left alone, got %v", restored.Spec.Selector)
+			}
+			if !equality.Semantic.DeepEqual(restored.Spec.Template.Labels, tt.labels) {
+				t.Errorf("labels = %v, want %v", restored.Spec.Template.Labels, tt.labels)
+			}
+			if len(restored.Spec.Template.Annotations) > 0 || len(tt.annotations) > 0 {
+				if !equality.Semantic.DeepEqual(restored.Spec.Template.Annotations, tt.annotations) {
+					t.Errorf("annotations = %v, want %v", restored.Spec.Template.Annotations, tt.annotations)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateLeavesMixedModeOnlyOnUpdate(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	d := newTestDeployment("default", "nginx")
+	d.Spec.Template.Spec.NodeSelector = map[string]string{PodNodeSelectorKey: "true"}
+
+	resp := api.mutate(newTestReview(t, admissionv1.Create, d))
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Errorf("expected unlisted create to pass untouched, got %s", resp.Patch)
+	}
+}
+
+func TestMutateLeavesMixedModeWithOverlay(t *testing.T) {
+	overlay := []byte(`{"schedulerName": "mixed", "containers": [{"name": "*", "env": [{"name": "A", "value": "1"}]}]}`)
+	original := newTestDeployment("default", "nginx")
+	original.Spec.Template.Spec.SchedulerName = "default-scheduler"
+
+	for _, tt := range []struct {
+		name  string
+		rules []*config.MixedRes
+	}{
+		{"flipped", []*config.MixedRes{{Namespace: "default", Name: "nginx", Mixed: false, Priority: 10, PodOverlay: overlay}}},
+		{"overlay dropped", []*config.MixedRes{{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10}}},
+		{"removed", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(log.NewNopLogger(), nil)
+			api.Update(&config.Config{Mixedreslist: []*config.MixedRes{
+				{Namespace: "default", Name: "nginx", Mixed: true, Priority: 10, PodOverlay: overlay},
+			}})
+			mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+			if spec := mixed.Spec.Template.Spec; spec.SchedulerName != "mixed" || len(spec.Containers[0].Env) != 1 {
+				t.Fatalf("expected the overlay to be applied, got scheduler %q, env %v", spec.SchedulerName, spec.Containers[0].Env)
+			}
+
+			api.Update(&config.Config{Mixedreslist: tt.rules})
+			updated := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+			spec := updated.Spec.Template.Spec
+			if spec.SchedulerName != "default-scheduler" || len(spec.Containers[0].Env) != 0 {
+				t.Errorf("expected the overlay to be undone, got scheduler %q, env %v", spec.SchedulerName, spec.Containers[0].Env)
+			}
+			if _, ok := updated.Spec.Template.Annotations[PodAnnotationOverlaidFieldsKey]; ok {
+				t.Errorf("expected the overlay record to be removed")
+			}
+		})
+	}
+}
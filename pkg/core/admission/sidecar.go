@@ -0,0 +1,104 @@
+package admission
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodAnnotationInjectedSidecarsKey lists the names of the containers that
+// were injected as sidecars, separated by commas.
+const PodAnnotationInjectedSidecarsKey string = "hc/injected-sidecars"
+
+// injectedSidecars returns the names of the containers recorded as injected
+// sidecars of a template.
+func injectedSidecars(template *corev1.PodTemplateSpec) map[string]bool {
+	injected := map[string]bool{}
+	if value := template.Annotations[PodAnnotationInjectedSidecarsKey]; value != "" {
+		for _, name := range strings.Split(value, ",") {
+			injected[name] = true
+		}
+	}
+	return injected
+}
+
+// mutateSidecars returns the patch that makes the injected sidecars of the
+// template the given ones: sidecars injected earlier are updated to the
+// rendered container or removed, and new ones are appended. A container the
+// workload defines itself is never touched, even if a sidecar has its name.
+func mutateSidecars(deployment *appsv1.Deployment, sidecars []*corev1.Container) (patch []PatchOperation) {
+	template := &deployment.Spec.Template
+	injected := injectedSidecars(template)
+	wanted := map[string]*corev1.Container{}
+	for _, s := range sidecars {
+		wanted[s.Name] = s
+	}
+
+	existing := map[string]bool{}
+	var removed []int
+	for index, c := range template.Spec.Containers {
+		existing[c.Name] = true
+		if !injected[c.Name] {
+			continue
+		}
+		s, ok := wanted[c.Name]
+		if !ok {
+			removed = append(removed, index)
+			continue
+		}
+		rendered, _ := toJSONValue(s)
+		current, _ := toJSONValue(c)
+		if !reflect.DeepEqual(rendered, current) {
+			patch = append(patch, PatchOperation{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/spec/template/spec/containers/%d", index),
+				Value: s,
+			})
+		}
+	}
+	// Remove from the back so that the indices stay valid.
+	for i := len(removed) - 1; i >= 0; i-- {
+		patch = append(patch, PatchOperation{
+			Op:   "remove",
+			Path: fmt.Sprintf("/spec/template/spec/containers/%d", removed[i]),
+		})
+	}
+
+	var names []string
+	for _, s := range sidecars {
+		if existing[s.Name] && !injected[s.Name] {
+			continue
+		}
+		names = append(names, s.Name)
+		if !existing[s.Name] {
+			patch = append(patch, PatchOperation{
+				Op:    "add",
+				Path:  "/spec/template/spec/containers/-",
+				Value: s,
+			})
+		}
+	}
+	sort.Strings(names)
+	return append(patch, recordSidecars(template, names)...)
+}
+
+// recordSidecars returns the patch that records names as the injected
+// sidecars of the template, removing the record if there are none.
+func recordSidecars(template *corev1.PodTemplateSpec, names []string) []PatchOperation {
+	value := strings.Join(names, ",")
+	current, ok := template.Annotations[PodAnnotationInjectedSidecarsKey]
+	switch {
+	case value == "" && ok:
+		return []PatchOperation{{
+			Op:   "remove",
+			Path: "/spec/template/metadata/annotations/" + escapeJSONPointer(PodAnnotationInjectedSidecarsKey),
+		}}
+	case value == "" || (ok && current == value):
+		return nil
+	}
+	return mutatePodAnnotations(template.Annotations, map[string]string{PodAnnotationInjectedSidecarsKey: value})
+}
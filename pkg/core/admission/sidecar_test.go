@@ -0,0 +1,90 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
+)
+
+func newSidecarConfig(t *testing.T, mixed bool, priority int64) *config.Config {
+	t.Helper()
+	conf := &config.Config{
+		Sidecars: []*config.Sidecar{{Name: "reporter", Container: []byte(`{
+			"name": "mixed-reporter",
+			"image": "registry.local/mixed/reporter:1.0",
+			"args": ["--workload={{ .Rule.Namespace }}/{{ .Rule.Name }}", "--priority={{ .Rule.Priority }}"],
+			"resources": {"requests": {"cpu": "10m", "memory": "16Mi"}, "limits": {"cpu": "50m", "memory": "32Mi"}}
+		}`)}},
+		Mixedreslist: []*config.MixedRes{
+			{Namespace: "default", Name: "nginx", Mixed: mixed, Priority: priority, Sidecars: []string{"reporter"}},
+		},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestMutateInjectsSidecars(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(newSidecarConfig(t, true, 10))
+
+	original := newTestDeployment("default", "nginx")
+	mixed := applyResponse(t, original, api.mutate(newTestReview(t, admissionv1.Create, original)))
+	containers := mixed.Spec.Template.Spec.Containers
+	if len(containers) != 2 || containers[1].Name != "mixed-reporter" {
+		t.Fatalf("expected the sidecar to be appended, got %v", containers)
+	}
+	if args := containers[1].Args; len(args) != 2 || args[0] != "--workload=default/nginx" || args[1] != "--priority=10" {
+		t.Errorf("sidecar args = %v", args)
+	}
+	if _, ok := containers[1].Resources.Requests[corev1.ResourceName(ContainerResourceCpuKey)]; !ok {
+		t.Errorf("expected the sidecar resources to be mixed, got %v", containers[1].Resources)
+	}
+	if v := mixed.Spec.Template.Annotations[PodAnnotationInjectedSidecarsKey]; v != "mixed-reporter" {
+		t.Errorf("injected sidecars annotation = %q", v)
+	}
+
+	again := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if !equality.Semantic.DeepEqual(again.Spec.Template, mixed.Spec.Template) {
+		t.Errorf("second mutation changed the template:\n%v\n%v", mixed.Spec.Template, again.Spec.Template)
+	}
+
+	api.Update(newSidecarConfig(t, true, 20))
+	updated := applyResponse(t, mixed, api.mutate(newTestReview(t, admissionv1.Update, mixed)))
+	if n := len(updated.Spec.Template.Spec.Containers); n != 2 {
+		t.Fatalf("expected the sidecar to be updated in place, got %d containers", n)
+	}
+	if args := updated.Spec.Template.Spec.Containers[1].Args; args[1] != "--priority=20" {
+		t.Errorf("sidecar args after priority change = %v", args)
+	}
+
+	api.Update(newSidecarConfig(t, false, 20))
+	restored := applyResponse(t, updated, api.mutate(newTestReview(t, admissionv1.Update, updated)))
+	if wasMixed(&restored.Spec.Template) {
+		t.Errorf("expected all mixed markers to be removed, got %v", restored.Spec.Template)
+	}
+	if !equality.Semantic.DeepEqual(restored.Spec.Template.Spec, original.Spec.Template.Spec) {
+		t.Errorf("expected original pod spec to be restored:\n%v\n%v", original.Spec.Template.Spec, restored.Spec.Template.Spec)
+	}
+}
+
+func TestMutateSidecarKeepsWorkloadContainer(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), nil)
+	api.Update(newSidecarConfig(t, true, 10))
+
+	d := newTestDeployment("default", "nginx")
+	d.Spec.Template.Spec.Containers[0].Name = "mixed-reporter"
+	mixed := applyResponse(t, d, api.mutate(newTestReview(t, admissionv1.Create, d)))
+	if c := mixed.Spec.Template.Spec.Containers; len(c) != 1 || c[0].Image != "nginx:1.21" {
+		t.Errorf("expected the workload's own container to be kept, got %v", c)
+	}
+	if _, ok := mixed.Spec.Template.Annotations[PodAnnotationInjectedSidecarsKey]; ok {
+		t.Errorf("expected no sidecar to be recorded")
+	}
+}
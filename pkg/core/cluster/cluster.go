@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Cluster gives read access to cluster state through shared informer caches,
+// so that admission decisions never wait on the API server.
+type Cluster struct {
+	logger  log.Logger
+	factory informers.SharedInformerFactory
+	synced  []cache.InformerSynced
+
+	Deployments appslisters.DeploymentLister
+	Namespaces  corelisters.NamespaceLister
+	// Nodes is nil unless WatchNodes was called.
+	Nodes corelisters.NodeLister
+}
+
+// New returns a Cluster watching through the given client. The caches are
+// filled once Run is called.
+func New(client kubernetes.Interface, resync time.Duration, logger log.Logger) *Cluster {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	factory := informers.NewSharedInformerFactory(client, resync)
+	deployments := factory.Apps().V1().Deployments()
+	namespaces := factory.Core().V1().Namespaces()
+
+	return &Cluster{
+		logger:  logger,
+		factory: factory,
+		synced: []cache.InformerSynced{
+			deployments.Informer().HasSynced,
+			namespaces.Informer().HasSynced,
+		},
+		Deployments: deployments.Lister(),
+		Namespaces:  namespaces.Lister(),
+	}
+}
+
+// WatchNodes adds a node informer, which the scheduling feasibility check
+// needs. It must be called before Run.
+func (c *Cluster) WatchNodes() {
+	nodes := c.factory.Core().V1().Nodes()
+	c.synced = append(c.synced, nodes.Informer().HasSynced)
+	c.Nodes = nodes.Lister()
+}
+
+// Run starts the informers and blocks until their caches have synced or ctx
+// is done. The informers keep running until ctx is done.
+func (c *Cluster) Run(ctx context.Context) error {
+	c.factory.Start(ctx.Done())
+	level.Info(c.logger).Log("msg", "Waiting for informer caches to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return errors.New("failed to sync informer caches")
+	}
+	level.Info(c.logger).Log("msg", "Informer caches synced")
+	return nil
+}
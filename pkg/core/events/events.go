@@ -0,0 +1,64 @@
+package events
+
+import (
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Component is the source component of the recorded events.
+const Component = "kubeadmission-webhook"
+
+// Recorder records Kubernetes events without ever blocking the caller.
+// Events are handed to a bounded queue that a background goroutine writes
+// to the API server; they are dropped when the queue is full or when more
+// events are recorded than the rate limit allows.
+type Recorder struct {
+	logger      log.Logger
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	limiter     flowcontrol.RateLimiter
+}
+
+// New returns a Recorder writing events through client, at most qps events
+// per second on average with bursts of up to burst events.
+func New(client kubernetes.Interface, qps float32, burst int, logger log.Logger) *Recorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	r := newRecorder(broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: Component}), qps, burst, logger)
+	r.broadcaster = broadcaster
+	return r
+}
+
+func newRecorder(recorder record.EventRecorder, qps float32, burst int, logger log.Logger) *Recorder {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Recorder{
+		logger:   logger,
+		recorder: recorder,
+		limiter:  flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+	}
+}
+
+// Eventf records an event about object, unless the rate limit is exceeded.
+func (r *Recorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if !r.limiter.TryAccept() {
+		level.Debug(r.logger).Log("msg", "Event rate limit exceeded, dropping event", "reason", reason)
+		return
+	}
+	r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// Shutdown stops writing events. Queued events may be lost.
+func (r *Recorder) Shutdown() {
+	if r.broadcaster != nil {
+		r.broadcaster.Shutdown()
+	}
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecorderRateLimit(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	r := newRecorder(fake, 0.001, 3, nil)
+
+	ref := &corev1.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "nginx"}
+	for i := 0; i < 5; i++ {
+		r.Eventf(ref, corev1.EventTypeNormal, "Mixed", "event %d", i)
+	}
+	if n := len(fake.Events); n != 3 {
+		t.Fatalf("expected the burst of 3 events to be recorded, got %d", n)
+	}
+	if e := <-fake.Events; e != "Normal Mixed event 0" {
+		t.Errorf("event = %q", e)
+	}
+}
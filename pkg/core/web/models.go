@@ -6,10 +6,13 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/atomic"
 
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/admission"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/cluster"
+	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/core/events"
 )
 
 // Options for the web Handler.
@@ -18,6 +21,14 @@ type Options struct {
 	EnableLifecycle bool
 	CertFile        string
 	KeyFile         string
+	// Registerer receives the admission metrics. Nil disables them.
+	Registerer prometheus.Registerer
+	// Cluster gives the admission API access to cluster state. Nil disables
+	// the checks that need it.
+	Cluster *cluster.Cluster
+	// Events records Kubernetes events about admitted workloads. Nil
+	// disables them.
+	Events *events.Recorder
 	// Flags           map[string]string
 }
 
@@ -3,11 +3,13 @@ package web
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	stdlog "log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"git.harmonycloud.cn/yeyazhou/kubeadmission-webhook/pkg/config"
@@ -15,6 +17,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func New(logger log.Logger, o *Options) *Handler {
@@ -39,12 +42,24 @@ func New(logger log.Logger, o *Options) *Handler {
 		cwd:      cwd,
 	}
 
-	h.admission = admission.NewAPI(logger)
+	h.admission = admission.NewAPI(logger, o.Cluster)
+	if o.Events != nil {
+		h.admission.SetEventRecorder(o.Events)
+	}
 	router.Mount("/admission", h.admission.Routes())
 
+	if o.Registerer != nil {
+		o.Registerer.MustRegister(h.admission)
+	}
+	router.Handle("/metrics", promhttp.Handler())
+
+	router.Get("/-/killswitch", h.killSwitch)
 	if o.EnableLifecycle {
 		router.Post("/-/reload", h.reload)
 		router.Put("/-/reload", h.reload)
+		router.Post("/-/killswitch", h.killSwitch)
+		router.Put("/-/killswitch", h.killSwitch)
+		router.Delete("/-/killswitch", h.killSwitch)
 	} else {
 		forbiddenAPINotEnabled := func(w http.ResponseWriter, _ *http.Request) {
 			w.WriteHeader(http.StatusForbidden)
@@ -53,6 +68,9 @@ func New(logger log.Logger, o *Options) *Handler {
 
 		router.Post("/-/reload", forbiddenAPINotEnabled)
 		router.Put("/-/reload", forbiddenAPINotEnabled)
+		router.Post("/-/killswitch", forbiddenAPINotEnabled)
+		router.Put("/-/killswitch", forbiddenAPINotEnabled)
+		router.Delete("/-/killswitch", forbiddenAPINotEnabled)
 	}
 
 	readyf := h.testReady
@@ -62,6 +80,9 @@ func New(logger log.Logger, o *Options) *Handler {
 	})
 	router.Get("/-/ready", readyf(func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "OK.\n")
+		if status := h.admission.KillSwitchStatus(); status.Engaged() {
+			io.WriteString(w, killSwitchSummary(status))
+		}
 	}))
 
 	return h
@@ -71,8 +92,10 @@ func (h *Handler) ApplyConfig(conf *config.Config) error {
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
 
+	if err := h.admission.Update(conf); err != nil {
+		return err
+	}
 	h.config = conf
-	h.admission.Update(conf)
 	return nil
 }
 
@@ -122,6 +145,44 @@ func (h *Handler) reload(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "OK")
 }
 
+// killSwitch engages the kill switch on POST and PUT and releases it on
+// DELETE, for the namespace query parameter or, without it, for every
+// namespace. It responds with the state of the kill switches.
+func (h *Handler) killSwitch(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		level.Warn(h.logger).Log("msg", "Engaging kill switch", "namespace", namespace)
+		h.admission.EngageKillSwitch(namespace)
+	case http.MethodDelete:
+		level.Warn(h.logger).Log("msg", "Releasing kill switch", "namespace", namespace)
+		h.admission.ReleaseKillSwitch(namespace)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.admission.KillSwitchStatus()); err != nil {
+		level.Error(h.logger).Log("err", err)
+	}
+}
+
+// killSwitchSummary describes the engaged kill switches for the readiness
+// output.
+func killSwitchSummary(status admission.KillSwitchStatus) string {
+	var b strings.Builder
+	for _, k := range []struct {
+		source string
+		config.KillSwitch
+	}{{admission.KillSwitchRuntime, status.Runtime}, {admission.KillSwitchConfig, status.Config}} {
+		switch {
+		case k.All:
+			fmt.Fprintf(&b, "Kill switch (%s) engaged for all namespaces.\n", k.source)
+		case len(k.Namespaces) > 0:
+			fmt.Fprintf(&b, "Kill switch (%s) engaged for namespaces %s.\n", k.source, strings.Join(k.Namespaces, ", "))
+		}
+	}
+	return b.String()
+}
+
 // Ready sets Handler to be ready.
 func (h *Handler) Ready() {
 	h.ready.Store(true)
@@ -0,0 +1,599 @@
+// Package expr implements a small, side-effect free expression language for
+// rule conditions. Expressions are compiled once and evaluated against
+// JSON-like values (maps, lists, strings, numbers, booleans and nil).
+//
+//	object.spec.replicas > 2 && request.operation == "CREATE"
+//	exists(object.spec.template.spec.containers, c, startsWith(c.image, "registry.local/"))
+//	!exists(object.spec.template.spec.volumes, v, has(v.persistentVolumeClaim))
+//
+// Supported are the operators || && ! == != < <= > >=, parentheses, string,
+// number and boolean literals, field selection with a dot, and the functions
+// listed in functions. exists and all bind a variable to every element of a
+// list in turn. Selecting a missing field yields null.
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Program is a compiled expression.
+type Program struct {
+	src  string
+	root node
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Compile parses src. Only the given variable names may be referenced at the
+// top level.
+func Compile(src string, vars ...string) (*Program, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, vars: map[string]int{}}
+	for _, v := range vars {
+		p.vars[v]++
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+// Eval evaluates the program with the given variables.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.root.eval(vars)
+}
+
+// EvalBool evaluates the program and requires a boolean result.
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q evaluated to %T, not bool", p.src, v)
+	}
+	return b, nil
+}
+
+// Lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ",", "."}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			text := src[i : j+1]
+			if c == '\'' {
+				text = `"` + strings.Replace(text[1:len(text)-1], `"`, `\"`, -1) + `"`
+			}
+			s, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %v", i, err)
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(toks, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	toks []token
+	pos  int
+	// vars counts the bindings of every name in scope.
+	vars map[string]int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q, found %q at offset %d", op, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			r, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return &binary{op: op, l: l, r: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	var x node
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		x = &literal{v: f}
+	case tokString:
+		x = &literal{v: t.text}
+	case tokIdent:
+		switch {
+		case t.text == "true" || t.text == "false":
+			x = &literal{v: t.text == "true"}
+		case t.text == "null":
+			x = &literal{v: nil}
+		case p.accept("("):
+			call, err := p.parseCall(t)
+			if err != nil {
+				return nil, err
+			}
+			x = call
+		default:
+			if p.vars[t.text] == 0 {
+				return nil, fmt.Errorf("unknown variable %q at offset %d", t.text, t.pos)
+			}
+			x = &variable{name: t.text}
+		}
+	case tokOp:
+		if t.text != "(" {
+			return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		x = inner
+	default:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	for p.accept(".") {
+		f := p.next()
+		if f.kind != tokIdent {
+			return nil, fmt.Errorf("expected field name, found %q at offset %d", f.text, f.pos)
+		}
+		x = &field{x: x, name: f.text}
+	}
+	return x, nil
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	if name.text == "exists" || name.text == "all" {
+		return p.parseMacro(name)
+	}
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+	var args []node
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name.text, fn.args, len(args))
+	}
+	return &call{name: name.text, fn: fn.fn, args: args}, nil
+}
+
+// parseMacro parses exists(list, var, condition) and all(list, var, condition).
+func (p *parser) parseMacro(name token) (node, error) {
+	list, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	v := p.next()
+	if v.kind != tokIdent {
+		return nil, fmt.Errorf("%s: expected variable name, found %q at offset %d", name.text, v.text, v.pos)
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	p.vars[v.text]++
+	body, err := p.parseOr()
+	p.vars[v.text]--
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &macro{all: name.text == "all", list: list, v: v.text, body: body}, nil
+}
+
+// Evaluation
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+
+func (n *literal) eval(map[string]interface{}) (interface{}, error) { return n.v, nil }
+
+type variable struct{ name string }
+
+func (n *variable) eval(vars map[string]interface{}) (interface{}, error) {
+	return normalize(vars[n.name]), nil
+}
+
+type field struct {
+	x    node
+	name string
+}
+
+func (n *field) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return normalize(m[n.name]), nil
+	}
+	return nil, nil
+}
+
+type not struct{ x node }
+
+func (n *not) eval(vars map[string]interface{}) (interface{}, error) {
+	b, err := evalBool(n.x, vars)
+	return !b, err
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (n *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	switch n.op {
+	case "&&", "||":
+		l, err := evalBool(n.l, vars)
+		if err != nil || l == (n.op == "||") {
+			return l, err
+		}
+		return evalBool(n.r, vars)
+	}
+
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	}
+
+	var cmp int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %T %s %T", l, n.op, r)
+		}
+		cmp = compareFloat(lv, rv)
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %T %s %T", l, n.op, r)
+		}
+		cmp = strings.Compare(lv, rv)
+	default:
+		return nil, fmt.Errorf("cannot compare %T %s %T", l, n.op, r)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type call struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+type macro struct {
+	all  bool
+	list node
+	v    string
+	body node
+}
+
+func (n *macro) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.list.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok && v != nil {
+		return nil, fmt.Errorf("cannot iterate over %T", v)
+	}
+
+	scope := make(map[string]interface{}, len(vars)+1)
+	for k, val := range vars {
+		scope[k] = val
+	}
+	for _, elem := range list {
+		scope[n.v] = elem
+		b, err := evalBool(n.body, scope)
+		if err != nil {
+			return nil, err
+		}
+		if b != n.all {
+			return b, nil
+		}
+	}
+	return n.all, nil
+}
+
+func evalBool(n node, vars map[string]interface{}) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %T", v)
+	}
+	return b, nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// normalize turns the numeric types produced outside of encoding/json into
+// float64, so that they compare equal to number literals.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v
+}
+
+var functions = map[string]struct {
+	args int
+	fn   func(args []interface{}) (interface{}, error)
+}{
+	"has": {1, func(a []interface{}) (interface{}, error) {
+		return a[0] != nil, nil
+	}},
+	"size": {1, func(a []interface{}) (interface{}, error) {
+		switch v := a[0].(type) {
+		case nil:
+			return float64(0), nil
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("no size for %T", a[0])
+	}},
+	"startsWith": {2, stringFunc(strings.HasPrefix)},
+	"endsWith":   {2, stringFunc(strings.HasSuffix)},
+	"contains": {2, func(a []interface{}) (interface{}, error) {
+		if list, ok := a[0].([]interface{}); ok {
+			for _, elem := range list {
+				if reflect.DeepEqual(elem, a[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return stringFunc(strings.Contains)(a)
+	}},
+}
+
+func stringFunc(f func(s, substr string) bool) func(a []interface{}) (interface{}, error) {
+	return func(a []interface{}) (interface{}, error) {
+		if a[0] == nil {
+			return false, nil
+		}
+		s, ok1 := a[0].(string)
+		sub, ok2 := a[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("expected strings, got %T and %T", a[0], a[1])
+		}
+		return f(s, sub), nil
+	}
+}
@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"metadata": {"name": "nginx", "labels": {"app": "nginx"}},
+		"spec": {
+			"replicas": 3,
+			"template": {"spec": {
+				"containers": [
+					{"name": "nginx", "image": "registry.local/nginx:1.21"},
+					{"name": "istio-proxy", "image": "docker.io/istio/proxyv2:1.14"}
+				],
+				"volumes": [{"name": "data", "persistentVolumeClaim": {"claimName": "data"}}]
+			}}
+		}
+	}`), &object); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]interface{}{
+		"object":  object,
+		"request": map[string]interface{}{"operation": "CREATE", "dryRun": false},
+	}
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`object.spec.replicas > 2`, true},
+		{`object.spec.replicas >= 4`, false},
+		{`object.spec.replicas == 3 && request.operation == "CREATE"`, true},
+		{`request.operation == 'UPDATE' || object.metadata.name != "nginx"`, false},
+		{`!(object.spec.replicas < 2)`, true},
+		{`object.spec.paused == null`, true},
+		{`has(object.metadata.labels.app) && !has(object.metadata.labels.tier)`, true},
+		{`size(object.spec.template.spec.containers) == 2`, true},
+		{`exists(object.spec.template.spec.containers, c, startsWith(c.image, "registry.local/"))`, true},
+		{`all(object.spec.template.spec.containers, c, startsWith(c.image, "registry.local/"))`, false},
+		{`exists(object.spec.template.spec.volumes, v, has(v.persistentVolumeClaim))`, true},
+		{`exists(object.spec.template.spec.initContainers, c, true)`, false},
+		{`all(object.spec.template.spec.initContainers, c, false)`, true},
+		{`contains(object.metadata.name, "gin") && endsWith(object.metadata.name, "x")`, true},
+		{`contains(object.spec.template.spec.containers, "nginx")`, false},
+		{`request.dryRun == false`, true},
+		{`object.spec.replicas > -1`, true},
+		// The right-hand side of || is not evaluated once the result is known.
+		{`true || object.spec.replicas > "x"`, true},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, "object", "request")
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got, err := p.EvalBool(vars)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`object.spec.replicas >`,
+		`unknown.field == 1`,
+		`object.spec.replicas > 2 extra`,
+		`nosuch(object)`,
+		`startsWith(object.metadata.name)`,
+		`exists(object.spec.template.spec.containers, c)`,
+		`exists(object.spec.template.spec.containers, c, true) && c.name == "x"`,
+		`"unterminated`,
+		`object.spec.replicas # 2`,
+	} {
+		if _, err := Compile(src, "object", "request"); err == nil {
+			t.Errorf("%q: expected compile error", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vars := map[string]interface{}{"object": map[string]interface{}{"name": "x", "replicas": float64(1)}}
+	for _, src := range []string{
+		`object.name > 1`,
+		`object.replicas && true`,
+		`exists(object.name, c, true)`,
+		`object.replicas`,
+	} {
+		p, err := Compile(src, "object")
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if _, err := p.EvalBool(vars); err == nil {
+			t.Errorf("%s: expected evaluation error", src)
+		}
+	}
+}